@@ -0,0 +1,323 @@
+// template-check 离线检查子设备模板（CommandRawList），并用抓到的响应报文预览解析结果，
+// 在部署前发现标识符个数与数量不符、公式错误、字节序选错等问题
+//
+// 用法：
+//
+//	go run ./cmd/template-check -template ./template.json
+//	go run ./cmd/template-check -template ./template.json -index 1 -response "01 03 04 41 48 00 00 6E 19"
+//
+// 模板可以是子设备表单配置 {"SlaveID":1,"CommandRawList":[...]}，也可以直接是CommandRawList数组。
+// 响应报文为十六进制，支持RTU帧（可不带CRC）和Modbus TCP帧。
+// 发现问题时退出码为1。
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	globaldata "github.com/ThingsPanel/modbus-protocol-plugin/global_data"
+	"github.com/ThingsPanel/modbus-protocol-plugin/modbus"
+	tpconfig "github.com/ThingsPanel/modbus-protocol-plugin/tp_config"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	templateFile := flag.String("template", "", "模板JSON文件，-表示从标准输入读取")
+	response := flag.String("response", "", "抓到的响应报文（十六进制，可含空格）")
+	index := flag.Int("index", 1, "响应报文对应的命令序号（从1开始）")
+	framing := flag.String("framing", "auto", "响应报文帧格式 auto|rtu|tcp")
+	subDeviceAddr := flag.String("addr", "1", "模板中没有SlaveID时使用的子设备地址")
+	level := flag.String("log", "error", "日志级别")
+	flag.Parse()
+
+	if l, err := logrus.ParseLevel(*level); err == nil {
+		logrus.SetLevel(l)
+	}
+	if *templateFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	formConfig, rawList, err := loadTemplate(*templateFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "读取模板失败: %v\n", err)
+		os.Exit(2)
+	}
+
+	failed := false
+	config, err := tpconfig.NewSubDeviceFormConfig(formConfig, *subDeviceAddr)
+	if err != nil {
+		fmt.Printf("模板无效: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("从站地址: %d，命令%d条\n", config.SlaveID, len(rawList))
+
+	// 逐条创建命令，NewSubDeviceFormConfig会跳过无效命令，这里逐条报告
+	commands := make([]*tpconfig.CommandRaw, len(rawList))
+	for i, raw := range rawList {
+		fmt.Println()
+		commandMap, ok := raw.(map[string]interface{})
+		if !ok {
+			fmt.Printf("命令#%d: 格式无效，应为对象\n", i+1)
+			failed = true
+			continue
+		}
+		cmd, err := tpconfig.NewCommandRaw(commandMap)
+		if err != nil {
+			fmt.Printf("命令#%d: 无法创建: %v\n", i+1, err)
+			failed = true
+			continue
+		}
+		commands[i] = cmd
+		if !checkCommand(i+1, cmd) {
+			failed = true
+		}
+	}
+
+	if *response != "" {
+		fmt.Println()
+		if *index < 1 || *index > len(commands) || commands[*index-1] == nil {
+			fmt.Printf("命令#%d不存在或无效，无法预览响应\n", *index)
+			os.Exit(1)
+		}
+		if !preview(*index, commands[*index-1], config.SlaveID, *response, *framing) {
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// loadTemplate 读取模板，返回表单配置和其中的命令列表
+func loadTemplate(path string) (map[string]interface{}, []interface{}, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var template interface{}
+	if err := json.Unmarshal(data, &template); err != nil {
+		return nil, nil, err
+	}
+	switch t := template.(type) {
+	case []interface{}:
+		return map[string]interface{}{"CommandRawList": t}, t, nil
+	case map[string]interface{}:
+		list, ok := t["CommandRawList"].([]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("缺少CommandRawList数组")
+		}
+		return t, list, nil
+	default:
+		return nil, nil, fmt.Errorf("模板应为对象或数组")
+	}
+}
+
+// checkCommand 打印命令概要、点位布局和校验结果，校验通过返回true
+func checkCommand(n int, cmd *tpconfig.CommandRaw) bool {
+	fmt.Printf("命令#%d: 功能码=0x%02X 起始地址=%d 数量=%d 数据类型=%s 字节序=%s 周期=%ds\n",
+		n, cmd.FunctionCode, cmd.StartingAddress, cmd.Quantity, cmd.DataType, cmd.Endianess, cmd.Interval)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  标识符\t地址\t公式\t小数位")
+	equations := strings.Split(cmd.EquationListStr, ",")
+	places := strings.Split(cmd.DecimalPlacesListStr, ",")
+	address := int(cmd.StartingAddress)
+	for i, id := range cmd.Identifiers() {
+		width := pointWidth(cmd.DataType)
+		span := fmt.Sprintf("%d", address)
+		if width > 1 {
+			span = fmt.Sprintf("%d-%d", address, address+width-1)
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", id, span, pick(cmd.EquationListStr, equations, i), pick(cmd.DecimalPlacesListStr, places, i))
+		address += width
+	}
+	w.Flush()
+
+	errs := cmd.Validate()
+	if len(errs) == 0 {
+		fmt.Println("  校验通过")
+		return true
+	}
+	for _, err := range errs {
+		fmt.Printf("  错误: %v\n", err)
+	}
+	return false
+}
+
+// pointWidth 每个点位占用的寄存器（线圈）数
+func pointWidth(dataType string) int {
+	switch dataType {
+	case "int32", "uint32", "float32":
+		return 2
+	case "int64", "float64":
+		return 4
+	default:
+		return 1
+	}
+}
+
+// pick 取第i个公式或小数位，只有一个时应用于全部标识符
+func pick(listStr string, list []string, i int) string {
+	switch {
+	case listStr == "":
+		return "-"
+	case len(list) == 1:
+		return strings.TrimSpace(list[0])
+	case i < len(list):
+		return strings.TrimSpace(list[i])
+	default:
+		return "-"
+	}
+}
+
+// preview 解析响应报文，按四种字节序分别打印每个点位的值，成功返回true
+func preview(n int, cmd *tpconfig.CommandRaw, slaveID uint8, responseHex, framing string) bool {
+	fmt.Printf("响应预览（命令#%d）:\n", n)
+	resp, err := parseResponse(responseHex, framing)
+	if err != nil {
+		fmt.Printf("  错误: %v\n", err)
+		return false
+	}
+
+	if resp[0] != slaveID {
+		fmt.Printf("  警告: 响应的从站地址为%d，模板为%d\n", resp[0], slaveID)
+	}
+	if resp[1] == cmd.FunctionCode|0x80 {
+		code := resp[2]
+		fmt.Printf("  错误: 异常响应 code=0x%02X，%s\n", code, globaldata.GetModbusErrorDesc(code))
+		return false
+	}
+	if resp[1] != cmd.FunctionCode {
+		fmt.Printf("  错误: 响应功能码0x%02X与命令功能码0x%02X不一致\n", resp[1], cmd.FunctionCode)
+		return false
+	}
+	byteCount := int(resp[2])
+	if want := cmd.DataLength(); byteCount != want {
+		fmt.Printf("  错误: 响应字节计数为%d，命令数量%d应返回%d字节\n", byteCount, cmd.Quantity, want)
+		return false
+	}
+	if len(resp) < 3+byteCount {
+		fmt.Printf("  错误: 响应数据不完整，字节计数为%d，实际只有%d字节\n", byteCount, len(resp)-3)
+		return false
+	}
+	fmt.Printf("  数据: % X\n", resp[3:3+byteCount])
+
+	ids := cmd.Identifiers()
+	// 标识符多于数量时按标识符解析会越界
+	need := len(ids) * pointWidth(cmd.DataType) * 2
+	if cmd.DataType == "coil" {
+		need = (len(ids) + 7) / 8
+	}
+	if need > byteCount {
+		fmt.Printf("  错误: %d个标识符需要%d字节数据，响应只有%d字节\n", len(ids), need, byteCount)
+		return false
+	}
+	results := make(map[string]map[string]interface{}, len(tpconfig.Endianesses))
+	raws := make(map[string]map[string]interface{}, len(tpconfig.Endianesses))
+	ok := true
+	for _, endianess := range tpconfig.Endianesses {
+		c := *cmd
+		c.Endianess = endianess
+		if results[endianess], err = c.Serialize(resp); err != nil {
+			fmt.Printf("  错误: 按%s解析失败: %v\n", endianess, err)
+			ok = false
+		}
+		c.EquationListStr = ""
+		c.DecimalPlacesListStr = ""
+		raws[endianess], _ = c.Serialize(resp)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := []string{"  标识符"}
+	for _, endianess := range tpconfig.Endianesses {
+		mark := ""
+		if endianess == cmd.Endianess {
+			mark = "*"
+		}
+		header = append(header, endianess+mark)
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, id := range ids {
+		row := []string{"  " + id}
+		for _, endianess := range tpconfig.Endianesses {
+			row = append(row, formatValue(results[endianess][id], raws[endianess][id]))
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+	fmt.Println("  *为模板当前字节序，公式计算后的值在前，括号内为原始值")
+	return ok
+}
+
+// parseResponse 解析十六进制响应报文，统一为RTU布局（从站地址、功能码、字节计数、数据）
+func parseResponse(responseHex, framing string) ([]byte, error) {
+	cleaned := strings.NewReplacer(" ", "", "\t", "", ":", "", "-", "", "0x", "", "0X", "").Replace(responseHex)
+	frame, err := hex.DecodeString(cleaned)
+	if err != nil {
+		return nil, fmt.Errorf("响应报文不是有效的十六进制: %v", err)
+	}
+
+	if framing == "auto" {
+		framing = "rtu"
+		if isTCPFrame(frame) {
+			framing = "tcp"
+		}
+	}
+	switch framing {
+	case "tcp":
+		if !isTCPFrame(frame) {
+			return nil, fmt.Errorf("不是有效的Modbus TCP帧")
+		}
+		fmt.Println("  帧格式: Modbus TCP")
+		// 去掉MBAP头中单元ID之前的部分
+		return frame[modbus.MBAPHeaderLength-1:], nil
+	case "rtu":
+		if len(frame) < 3 {
+			return nil, fmt.Errorf("响应报文太短: %d字节", len(frame))
+		}
+		fmt.Println("  帧格式: RTU")
+		if frame[1]&0x80 == 0 && len(frame) == 3+int(frame[2])+2 {
+			if !modbus.ValidCRC(frame) {
+				fmt.Println("  警告: CRC校验失败")
+			}
+		} else if frame[1]&0x80 == 0 && len(frame) == 3+int(frame[2]) {
+			fmt.Println("  提示: 报文不含CRC")
+		}
+		return frame, nil
+	default:
+		return nil, fmt.Errorf("不支持的帧格式: %s", framing)
+	}
+}
+
+// isTCPFrame MBAP头中协议标识为0且长度与报文一致
+func isTCPFrame(frame []byte) bool {
+	if len(frame) < modbus.MBAPHeaderLength+2 {
+		return false
+	}
+	return frame[2] == 0 && frame[3] == 0 && int(frame[4])<<8|int(frame[5]) == len(frame)-6
+}
+
+func formatValue(value, raw interface{}) string {
+	if value == nil {
+		return "-"
+	}
+	if raw == nil || fmt.Sprint(raw) == fmt.Sprint(value) {
+		return fmt.Sprint(value)
+	}
+	return fmt.Sprintf("%v (%v)", value, raw)
+}
@@ -0,0 +1,400 @@
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	httpclient "github.com/ThingsPanel/modbus-protocol-plugin/http_client"
+	httpserver "github.com/ThingsPanel/modbus-protocol-plugin/http_server"
+	services "github.com/ThingsPanel/modbus-protocol-plugin/services"
+	"github.com/ThingsPanel/modbus-protocol-plugin/simulator"
+	"github.com/spf13/viper"
+)
+
+// holdingSlave 从站配置：保持寄存器从0开始
+func holdingSlave(id uint8, values ...uint16) simulator.SlaveConfig {
+	return simulator.SlaveConfig{
+		ID:               id,
+		HoldingRegisters: []simulator.RegisterBlock{{Start: 0, Values: values}},
+	}
+}
+
+func TestTelemetry(t *testing.T) {
+	cases := []struct {
+		name     string
+		protocol string
+		framing  string
+		addr     func() string
+	}{
+		{"RTU", "MODBUS_RTU", simulator.FramingRTU, func() string { return h.rtuAddr }},
+		{"TCP", "MODBUS_TCP", simulator.FramingTCP, func() string { return h.tcpAddr }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			regPkg := uniqueRegPkg(t)
+			subID := regPkg + "-sub1"
+			gatewayID := h.addGateway(regPkg, c.protocol,
+				subDevice(subID, 1, readCommand(0x03, 0, 2, "int16", "A1,A2")))
+			h.startDTU(t, simulator.DTUConfig{
+				Address:      c.addr(),
+				Framing:      c.framing,
+				Registration: regPkg,
+				Slaves:       []simulator.SlaveConfig{holdingSlave(1, 123, 456)},
+			})
+
+			h.waitStatus(t, gatewayID, "1")
+			h.waitTelemetry(t, subID, map[string]float64{"A1": 123, "A2": 456})
+		})
+	}
+}
+
+// TestTelemetryWithHeartbeatAndFragments 心跳包穿插在响应之间、响应分两包到达时仍能正确解析
+func TestTelemetryWithHeartbeatAndFragments(t *testing.T) {
+	regPkg := uniqueRegPkg(t)
+	subID := regPkg + "-sub1"
+	h.addGateway(regPkg, "MODBUS_RTU", subDevice(subID, 1, readCommand(0x03, 0, 1, "int16", "A1")))
+	dtu, _ := h.startDTU(t, simulator.DTUConfig{
+		Address:           h.rtuAddr,
+		Framing:           simulator.FramingRTU,
+		Registration:      regPkg,
+		Heartbeat:         regPkg,
+		HeartbeatInterval: 100 * time.Millisecond,
+		Slaves:            []simulator.SlaveConfig{holdingSlave(1, 42)},
+		Faults:            simulator.Faults{FragmentRate: 1, FragmentDelay: 20 * time.Millisecond},
+	})
+
+	h.waitTelemetry(t, subID, map[string]float64{"A1": 42})
+	// 修改寄存器后上报新值
+	dtu.Slave(1).SetHoldingRegister(0, 43)
+	h.waitTelemetry(t, subID, map[string]float64{"A1": 43})
+}
+
+func TestOfflineStatus(t *testing.T) {
+	regPkg := uniqueRegPkg(t)
+	gatewayID := h.addGateway(regPkg, "MODBUS_TCP",
+		subDevice(regPkg+"-sub1", 1, readCommand(0x03, 0, 1, "int16", "A1")))
+	_, stop := h.startDTU(t, simulator.DTUConfig{
+		Address:      h.tcpAddr,
+		Framing:      simulator.FramingTCP,
+		Registration: regPkg,
+		Slaves:       []simulator.SlaveConfig{holdingSlave(1, 1)},
+	})
+
+	h.waitStatus(t, gatewayID, "1")
+	stop()
+	h.waitStatus(t, gatewayID, "0")
+}
+
+func TestUnknownGatewayRejected(t *testing.T) {
+	regPkg := uniqueRegPkg(t)
+	dtu, _ := h.startDTU(t, simulator.DTUConfig{
+		Address:      h.rtuAddr,
+		Registration: regPkg,
+		Slaves:       []simulator.SlaveConfig{holdingSlave(1, 1)},
+	})
+
+	// 平台上不存在的网关被断开，DTU不断重连，但不会收到任何请求
+	deadline := time.Now().Add(5 * time.Second)
+	for dtu.Stats().Connects < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("未知网关的连接没有被断开")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if requests := dtu.Stats().Requests; requests != 0 {
+		t.Fatalf("未知网关收到了%d个请求", requests)
+	}
+}
+
+func TestControlRoundTrip(t *testing.T) {
+	cases := []struct {
+		name     string
+		protocol string
+		framing  string
+		addr     func() string
+	}{
+		{"RTU", "MODBUS_RTU", simulator.FramingRTU, func() string { return h.rtuAddr }},
+		{"TCP", "MODBUS_TCP", simulator.FramingTCP, func() string { return h.tcpAddr }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			regPkg := uniqueRegPkg(t)
+			subID := regPkg + "-sub1"
+			h.addGateway(regPkg, c.protocol, subDevice(subID, 1, readCommand(0x03, 0, 2, "int16", "A1,A2")))
+			dtu, _ := h.startDTU(t, simulator.DTUConfig{
+				Address:      c.addr(),
+				Framing:      c.framing,
+				Registration: regPkg,
+				Slaves:       []simulator.SlaveConfig{holdingSlave(1, 10, 20)},
+			})
+			// 采集开始后子设备配置已加载，可以下发控制
+			h.waitTelemetry(t, subID, map[string]float64{"A1": 10, "A2": 20})
+
+			h.publish(t, controlTopic+"/"+subID, `{"A2": 789}`)
+
+			// 从站寄存器被写入
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				if v, _ := dtu.Slave(1).HoldingRegister(1); v == 789 {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("控制命令没有写入从站")
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			// 控制成功后回报写入的值，之后的采集也读到新值
+			h.waitTelemetry(t, subID, map[string]float64{"A2": 789})
+			h.waitTelemetry(t, subID, map[string]float64{"A1": 10, "A2": 789})
+		})
+	}
+}
+
+func TestServiceHeartbeat(t *testing.T) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		h.mutex.Lock()
+		rtu, tcp := h.heartbeats["MODBUS_RTU"], h.heartbeats["MODBUS_TCP"]
+		h.mutex.Unlock()
+		if rtu > 0 && tcp > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("服务心跳未上报: MODBUS_RTU=%d, MODBUS_TCP=%d", rtu, tcp)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// TestScanOverMQTT 通过MQTT下发扫描请求，扫描期间正常采集继续进行
+func TestScanOverMQTT(t *testing.T) {
+	regPkg := uniqueRegPkg(t)
+	subID := regPkg + "-sub1"
+	gatewayID := h.addGateway(regPkg, "MODBUS_RTU", subDevice(subID, 1, readCommand(0x03, 0, 1, "int16", "A1")))
+	slave1 := simulator.SlaveConfig{
+		ID: 1,
+		HoldingRegisters: []simulator.RegisterBlock{
+			{Start: 0, Values: make([]uint16, 10)},
+			{Start: 20, Values: make([]uint16, 5)},
+		},
+	}
+	slave3 := holdingSlave(3, 1, 2, 3)
+	h.startDTU(t, simulator.DTUConfig{
+		Address:      h.rtuAddr,
+		Framing:      simulator.FramingRTU,
+		Registration: regPkg,
+		Slaves:       []simulator.SlaveConfig{slave1, slave3},
+	})
+	h.waitStatus(t, gatewayID, "1")
+
+	h.publish(t, scanTopic, fmt.Sprintf(`{"gateway_id":%q,"slave_from":1,"slave_to":4,"ranges":[{"function_code":3,"start":0,"end":29}],"timeout_ms":200,"block_size":8}`, gatewayID))
+
+	var result services.ScanResult
+	h.waitMessage(t, 20*time.Second, "扫描结果", func(msg message) bool {
+		return msg.Topic == scanResultTopic+"/"+gatewayID && json.Unmarshal(msg.Payload, &result) == nil && result.State != services.ScanRunning
+	})
+	if result.State != services.ScanDone {
+		t.Fatalf("扫描失败: %+v", result)
+	}
+	if fmt.Sprint(result.FoundSlaves) != "[1 3]" {
+		t.Fatalf("发现的从站为%v，期望[1 3]", result.FoundSlaves)
+	}
+	expected := map[uint8]string{
+		1: "[{3 0 9} {3 20 24}]",
+		3: "[{3 0 2}]",
+	}
+	for _, slave := range result.Slaves {
+		if got := fmt.Sprint(slave.Segments); got != expected[slave.SlaveID] {
+			t.Fatalf("从站%d的有效地址为%s，期望%s", slave.SlaveID, got, expected[slave.SlaveID])
+		}
+	}
+	commands := result.Slaves[1].Template["CommandRawList"].([]interface{})
+	if command := commands[0].(map[string]interface{}); command["DataIdentifierListStr"] != "HR0,HR1,HR2" || command["Quantity"] != float64(3) {
+		t.Fatalf("草稿模板不正确: %v", command)
+	}
+
+	// 扫描与采集共用总线锁，采集不受影响
+	h.waitTelemetry(t, subID, map[string]float64{"A1": 0})
+}
+
+// TestScanDigitSlaveIDs 扫描能发现地址48-57（ASCII数字）的从站
+func TestScanDigitSlaveIDs(t *testing.T) {
+	regPkg := uniqueRegPkg(t)
+	gatewayID := h.addGateway(regPkg, "MODBUS_RTU")
+	h.startDTU(t, simulator.DTUConfig{
+		Address:      h.rtuAddr,
+		Framing:      simulator.FramingRTU,
+		Registration: regPkg,
+		Slaves:       []simulator.SlaveConfig{holdingSlave(48, 1, 2), holdingSlave(57, 3)},
+	})
+	h.waitStatus(t, gatewayID, "1")
+
+	h.publish(t, scanTopic, fmt.Sprintf(`{"gateway_id":%q,"slave_from":47,"slave_to":58,"ranges":[{"function_code":3,"start":0,"end":3}],"timeout_ms":100,"block_size":4}`, gatewayID))
+
+	var result services.ScanResult
+	h.waitMessage(t, 20*time.Second, "扫描结果", func(msg message) bool {
+		return msg.Topic == scanResultTopic+"/"+gatewayID && json.Unmarshal(msg.Payload, &result) == nil && result.State != services.ScanRunning
+	})
+	if result.State != services.ScanDone {
+		t.Fatalf("扫描失败: %+v", result)
+	}
+	if fmt.Sprint(result.FoundSlaves) != "[48 57]" {
+		t.Fatalf("发现的从站为%v，期望[48 57]", result.FoundSlaves)
+	}
+}
+
+// TestMQTTReconnect 代理断开插件连接后，插件自动重连、重新订阅并重新上报网关在线状态
+func TestMQTTReconnect(t *testing.T) {
+	regPkg := uniqueRegPkg(t)
+	subID := regPkg + "-sub1"
+	gatewayID := h.addGateway(regPkg, "MODBUS_TCP", subDevice(subID, 1, readCommand(0x03, 0, 1, "int16", "A1")))
+	dtu, _ := h.startDTU(t, simulator.DTUConfig{
+		Address:      h.tcpAddr,
+		Framing:      simulator.FramingTCP,
+		Registration: regPkg,
+		Slaves:       []simulator.SlaveConfig{holdingSlave(1, 5)},
+	})
+	h.waitStatus(t, gatewayID, "1")
+	h.waitTelemetry(t, subID, map[string]float64{"A1": 5})
+
+	since := h.messageCount()
+	h.kickClients()
+	h.waitMessageSince(t, since, 15*time.Second, "重连后重新上报网关状态", func(msg message) bool {
+		return msg.Topic == statusTopic+"/"+gatewayID && string(msg.Payload) == "1"
+	})
+
+	// 重连后控制主题重新订阅
+	h.publish(t, controlTopic+"/"+subID, `{"A1": 6}`)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if v, _ := dtu.Slave(1).HoldingRegister(0); v == 6 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("重连后控制命令没有写入从站")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// TestOfflineQueueReplay 代理不可用期间的遥测写入离线队列，代理恢复后按采集顺序重发，采集时间保持原值
+func TestOfflineQueueReplay(t *testing.T) {
+	regPkg := uniqueRegPkg(t)
+	subID := regPkg + "-sub1"
+	gatewayID := h.addGateway(regPkg, "MODBUS_RTU", subDevice(subID, 1, readCommand(0x03, 0, 1, "int16", "A1")))
+	dtu, _ := h.startDTU(t, simulator.DTUConfig{
+		Address:      h.rtuAddr,
+		Framing:      simulator.FramingRTU,
+		Registration: regPkg,
+		Slaves:       []simulator.SlaveConfig{holdingSlave(1, 0)},
+	})
+	h.waitStatus(t, gatewayID, "1")
+	h.waitTelemetry(t, subID, map[string]float64{"A1": 0})
+
+	h.stopBroker()
+	stoppedAt := time.Now()
+	// 代理不可用期间寄存器依次变化，每个值至少被采集一次
+	for v := uint16(1); v <= 3; v++ {
+		dtu.Slave(1).SetHoldingRegister(0, v)
+		requests := dtu.Stats().Requests
+		deadline := time.Now().Add(5 * time.Second)
+		for dtu.Stats().Requests < requests+2 {
+			if time.Now().After(deadline) {
+				t.Fatal("代理不可用期间采集停止")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	since := h.messageCount()
+	restartedAt := time.Now()
+	if err := h.startBroker(); err != nil {
+		t.Fatalf("重启代理失败: %v", err)
+	}
+	h.waitMessageSince(t, since, 15*time.Second, "重发离线期间的遥测", func(msg message) bool {
+		deviceID, values, ok := telemetry(msg)
+		return ok && deviceID == subID && values["A1"] == float64(3)
+	})
+
+	var sequence []float64
+	h.mutex.Lock()
+	for _, msg := range h.messages[since:] {
+		deviceID, values, ok := telemetry(msg)
+		if !ok || deviceID != subID {
+			continue
+		}
+		value := values["A1"].(float64)
+		if len(sequence) == 0 || sequence[len(sequence)-1] != value {
+			sequence = append(sequence, value)
+		}
+		var payload struct {
+			Ts int64 `json:"ts"`
+		}
+		json.Unmarshal(msg.Payload, &payload)
+		if value == 1 || value == 2 {
+			if payload.Ts < stoppedAt.UnixMilli() || payload.Ts >= restartedAt.UnixMilli() {
+				t.Errorf("A1=%v的采集时间%d不在代理不可用期间", value, payload.Ts)
+			}
+		}
+	}
+	h.mutex.Unlock()
+	// 代理停止瞬间的采集可能也进入队列
+	if got := fmt.Sprint(sequence); got != "[1 2 3]" && got != "[0 1 2 3]" {
+		t.Fatalf("重发顺序为%s，期望[1 2 3]", got)
+	}
+}
+
+// TestNotifyEventReloadsConfig 平台通知配置修改后重新获取网关配置，新增的子设备开始采集，不断开网关连接
+func TestNotifyEventReloadsConfig(t *testing.T) {
+	regPkg := uniqueRegPkg(t)
+	sub1 := regPkg + "-sub1"
+	sub2 := regPkg + "-sub2"
+	h.addGateway(regPkg, "MODBUS_TCP", subDevice(sub1, 1, readCommand(0x03, 0, 1, "int16", "A1")))
+	viper.Set("config_cache.enabled", true)
+	viper.Set("config_cache.dir", t.TempDir())
+	defer viper.Set("config_cache.enabled", false)
+	dtu, _ := h.startDTU(t, simulator.DTUConfig{
+		Address:      h.tcpAddr,
+		Framing:      simulator.FramingTCP,
+		Registration: regPkg,
+		Slaves:       []simulator.SlaveConfig{holdingSlave(1, 11), holdingSlave(2, 22)},
+	})
+	h.waitTelemetry(t, sub1, map[string]float64{"A1": 11})
+	connects := dtu.Stats().Connects
+
+	// 平台上新增子设备后发送通知事件
+	h.addGateway(regPkg, "MODBUS_TCP",
+		subDevice(sub1, 1, readCommand(0x03, 0, 1, "int16", "A1")),
+		subDevice(sub2, 2, readCommand(0x03, 0, 1, "int16", "B1")))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/notify/event",
+		strings.NewReader(`{"message_type":"1","message":"service config updated"}`))
+	rec := httptest.NewRecorder()
+	httpserver.OnNotifyEvent(rec, req)
+	var rsp struct {
+		Code int `json:"code"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &rsp); err != nil || rsp.Code != 200 {
+		t.Fatalf("通知事件处理失败: %s", rec.Body.String())
+	}
+
+	h.waitTelemetry(t, sub2, map[string]float64{"B1": 22})
+	if got := dtu.Stats().Connects; got != connects {
+		t.Fatalf("重新加载配置时网关连接被断开: 连接次数%d -> %d", connects, got)
+	}
+
+	// 本地配置缓存已刷新，平台不可用时使用的是新配置
+	h.setPlatformDown(true)
+	defer h.setPlatformDown(false)
+	cached, fromCache, err := httpclient.GetDeviceConfigWithCache(`{"reg_pkg":"` + regPkg + `"}`)
+	if err != nil || !fromCache {
+		t.Fatalf("未使用本地缓存: fromCache=%v, err=%v", fromCache, err)
+	}
+	if n := len(cached.Data.SubDevices); n != 2 {
+		t.Fatalf("本地缓存的子设备数为%d，期望2", n)
+	}
+}
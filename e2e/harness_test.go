@@ -0,0 +1,446 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpclient "github.com/ThingsPanel/modbus-protocol-plugin/http_client"
+	MQTT "github.com/ThingsPanel/modbus-protocol-plugin/mqtt"
+	services "github.com/ThingsPanel/modbus-protocol-plugin/services"
+	"github.com/ThingsPanel/modbus-protocol-plugin/simulator"
+	"github.com/ThingsPanel/tp-protocol-sdk-go/api"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// 测试环境使用的主题
+const (
+	telemetryTopic  = "devices/telemetry"
+	statusTopic     = "devices/status"
+	controlTopic    = "plugin/modbus/devices/telemetry/control"
+	scanTopic       = "plugin/modbus/scan"
+	scanResultTopic = "modbus/scan/result"
+)
+
+// harness 在进程内启动插件的各个子系统：平台接口由httptest模拟，MQTT代理内嵌
+// 插件使用全局状态，所有测试共用一个harness，各测试使用不同的注册包互不干扰
+type harness struct {
+	broker     *mochi.Server
+	brokerAddr string
+	platform   *httptest.Server
+	rtuAddr    string // MODBUS_RTU监听地址
+	tcpAddr    string // MODBUS_TCP监听地址
+	dataDir    string
+
+	certs       *certFiles     // 测试用证书
+	plcListener net.Listener   // 模拟的Modbus/TCP Security服务端
+	plcAddr     string         // 插件主动连接的地址
+	plc         *simulator.DTU // 服务端上的从站，为nil时拒绝连接
+	plcClientCN string         // 服务端收到的客户端证书CN
+
+	mutex        sync.Mutex
+	platformDown bool                                    // 模拟平台配置接口不可用
+	gateways     map[string]api.DeviceConfigResponseData // 注册包 -> 网关配置
+	heartbeats   map[string]int                          // 服务标识符 -> 心跳次数
+	messages     []message                               // 代理收到的所有消息
+}
+
+// message 代理收到的一条消息
+type message struct {
+	Topic   string
+	Payload []byte
+}
+
+var h *harness
+
+func TestMain(m *testing.M) {
+	if os.Getenv("E2E_LOG") == "" {
+		logrus.SetOutput(io.Discard)
+	}
+	var err error
+	h, err = startHarness()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "启动测试环境失败: %v\n", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	h.close()
+	os.Exit(code)
+}
+
+// freeAddress 获取一个空闲的本地端口
+func freeAddress() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+func startHarness() (*harness, error) {
+	dataDir, err := os.MkdirTemp("", "modbus-e2e-")
+	if err != nil {
+		return nil, err
+	}
+	h := &harness{
+		dataDir:    dataDir,
+		gateways:   make(map[string]api.DeviceConfigResponseData),
+		heartbeats: make(map[string]int),
+	}
+
+	// 内嵌MQTT代理，内联客户端订阅所有消息
+	if h.brokerAddr, err = freeAddress(); err != nil {
+		return nil, err
+	}
+	if err := h.startBroker(); err != nil {
+		return nil, err
+	}
+
+	// 模拟平台接口
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/plugin/device/config", h.onDeviceConfig)
+	mux.HandleFunc("/api/v1/plugin/heartbeat", h.onHeartbeat)
+	h.platform = httptest.NewServer(mux)
+
+	if h.rtuAddr, err = freeAddress(); err != nil {
+		return nil, err
+	}
+	if h.tcpAddr, err = freeAddress(); err != nil {
+		return nil, err
+	}
+	if err := h.startPLC(); err != nil {
+		return nil, err
+	}
+
+	configure(h)
+	// 启动顺序与main一致
+	MQTT.InitClient()
+	httpclient.Init()
+	services.Start()
+	MQTT.Subscribe()
+
+	// 等待监听器启动
+	deadline := time.Now().Add(5 * time.Second)
+	for _, addr := range []string{h.rtuAddr, h.tcpAddr} {
+		for {
+			conn, err := net.Dial("tcp", addr)
+			if err == nil {
+				conn.Close()
+				break
+			}
+			if time.Now().After(deadline) {
+				return nil, fmt.Errorf("监听器未启动: %s", addr)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	return h, nil
+}
+
+// configure 测试环境的插件配置，数据文件写入临时目录
+func configure(h *harness) {
+	viper.Reset()
+	viper.Set("mqtt.broker", "tcp://"+h.brokerAddr)
+	viper.Set("mqtt.connect_retry.max_interval", "1s")
+	viper.Set("mqtt.topic_to_publish_sub", telemetryTopic)
+	viper.Set("mqtt.topic_to_subscribe", "plugin/modbus/#")
+	viper.Set("mqtt.scan_topic", scanTopic)
+	viper.Set("mqtt.scan_result_topic", scanResultTopic)
+	viper.Set("mqtt.qos", 1)
+	viper.Set("thingspanel.address", h.platform.URL)
+	viper.Set("server.listeners", []map[string]interface{}{
+		{"name": "rtu", "address": h.rtuAddr, "protocol": "MODBUS_RTU", "identifier": "MODBUS_RTU"},
+		{"name": "tcp", "address": h.tcpAddr, "protocol": "MODBUS_TCP", "identifier": "MODBUS_TCP"},
+	})
+	viper.Set("server.outbound", []map[string]interface{}{{
+		"name":               "plc",
+		"address":            h.plcAddr,
+		"reg_pkg":            outboundRegPkg,
+		"reconnect_interval": "200ms",
+		"tls": map[string]interface{}{
+			"enabled":   true,
+			"ca_file":   h.certs.caFile,
+			"cert_file": h.certs.clientCertFile,
+			"key_file":  h.certs.clientKeyFile,
+		},
+	}})
+	viper.Set("connection_limit.registration_timeout", "5s")
+	viper.Set("access_control.persist_file", "")
+	viper.Set("audit.file", filepath.Join(h.dataDir, "audit.log"))
+	viper.Set("config_cache.enabled", false)
+	viper.Set("offline_queue.enabled", true)
+	viper.Set("offline_queue.dir", filepath.Join(h.dataDir, "offline_queue"))
+}
+
+// startBroker 在固定地址启动内嵌MQTT代理
+func (h *harness) startBroker() error {
+	broker := mochi.New(&mochi.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err := broker.AddHook(new(auth.AllowHook), nil); err != nil {
+		return err
+	}
+	if err := broker.AddListener(listeners.NewTCP(listeners.Config{ID: "e2e", Address: h.brokerAddr})); err != nil {
+		return err
+	}
+	if err := broker.Serve(); err != nil {
+		return err
+	}
+	if err := broker.Subscribe("#", 1, h.onMessage); err != nil {
+		return err
+	}
+	h.broker = broker
+	return nil
+}
+
+// stopBroker 停止MQTT代理，模拟代理不可用
+func (h *harness) stopBroker() {
+	h.broker.Close()
+}
+
+func (h *harness) close() {
+	h.plcListener.Close()
+	h.platform.Close()
+	h.broker.Close()
+	os.RemoveAll(h.dataDir)
+}
+
+func (h *harness) onMessage(cl *mochi.Client, sub packets.Subscription, pk packets.Packet) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.messages = append(h.messages, message{Topic: pk.TopicName, Payload: append([]byte(nil), pk.Payload...)})
+}
+
+// onDeviceConfig 按凭证中的注册包返回网关配置
+func (h *harness) onDeviceConfig(w http.ResponseWriter, r *http.Request) {
+	var req api.DeviceConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var voucher struct {
+		RegPkg string `json:"reg_pkg"`
+	}
+	json.Unmarshal([]byte(req.Voucher), &voucher)
+
+	h.mutex.Lock()
+	if h.platformDown {
+		h.mutex.Unlock()
+		http.Error(w, "platform unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	config, ok := h.gateways[voucher.RegPkg]
+	h.mutex.Unlock()
+	if !ok {
+		json.NewEncoder(w).Encode(api.DeviceConfigResponse{Code: 400, Message: "设备不存在"})
+		return
+	}
+	json.NewEncoder(w).Encode(api.DeviceConfigResponse{Code: 200, Message: "success", Data: config})
+}
+
+func (h *harness) onHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var req api.HeartbeatRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	h.mutex.Lock()
+	h.heartbeats[req.ServiceIdentifier]++
+	h.mutex.Unlock()
+	json.NewEncoder(w).Encode(api.HeartbeatResponseData{Code: 200, Message: "success"})
+}
+
+// setPlatformDown 设置模拟平台配置接口是否不可用
+func (h *harness) setPlatformDown(down bool) {
+	h.mutex.Lock()
+	h.platformDown = down
+	h.mutex.Unlock()
+}
+
+// addGateway 在模拟平台上登记网关，返回网关ID
+func (h *harness) addGateway(regPkg string, protocol string, subDevices ...api.SubDevice) string {
+	id := "gw-" + regPkg
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.gateways[regPkg] = api.DeviceConfigResponseData{
+		ID:           id,
+		Voucher:      `{"reg_pkg":"` + regPkg + `"}`,
+		DeviceType:   "2",
+		ProtocolType: protocol,
+		SubDevices:   subDevices,
+	}
+	return id
+}
+
+// subDevice 子设备配置
+func subDevice(deviceID string, slaveID uint8, commands ...map[string]interface{}) api.SubDevice {
+	list := make([]interface{}, 0, len(commands))
+	for _, command := range commands {
+		list = append(list, command)
+	}
+	return api.SubDevice{
+		DeviceID:      deviceID,
+		SubDeviceAddr: fmt.Sprint(slaveID),
+		ProtocolConfigTemplate: map[string]interface{}{
+			"SlaveID":        float64(slaveID),
+			"CommandRawList": list,
+		},
+	}
+}
+
+// readCommand 采集命令配置，1秒采集一次
+func readCommand(functionCode byte, start uint16, quantity uint16, dataType string, identifiers string) map[string]interface{} {
+	return map[string]interface{}{
+		"FunctionCode":          float64(functionCode),
+		"StartingAddress":       float64(start),
+		"Quantity":              float64(quantity),
+		"Endianess":             "BIG",
+		"Interval":              float64(1),
+		"DataType":              dataType,
+		"DataIdentifierListStr": identifiers,
+	}
+}
+
+// startDTU 启动模拟DTU，测试结束时断开
+func (h *harness) startDTU(t *testing.T, config simulator.DTUConfig) (*simulator.DTU, context.CancelFunc) {
+	t.Helper()
+	if config.ReconnectInterval == 0 {
+		config.ReconnectInterval = 200 * time.Millisecond
+	}
+	dtu, err := simulator.NewDTU(config)
+	if err != nil {
+		t.Fatalf("创建模拟DTU失败: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dtu.Run(ctx)
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return dtu, stop
+}
+
+// publish 模拟平台下发消息
+func (h *harness) publish(t *testing.T, topic string, payload string) {
+	t.Helper()
+	if err := h.broker.Publish(topic, []byte(payload), false, 1); err != nil {
+		t.Fatalf("发布消息失败: %v", err)
+	}
+}
+
+// waitMessage 等待满足条件的消息，包括等待开始前已收到的消息
+func (h *harness) waitMessage(t *testing.T, timeout time.Duration, what string, match func(message) bool) message {
+	t.Helper()
+	return h.waitMessageSince(t, 0, timeout, what, match)
+}
+
+// waitMessageSince 等待第since条之后满足条件的消息
+func (h *harness) waitMessageSince(t *testing.T, since int, timeout time.Duration, what string, match func(message) bool) message {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		h.mutex.Lock()
+		for _, msg := range h.messages[since:] {
+			if match(msg) {
+				h.mutex.Unlock()
+				return msg
+			}
+		}
+		h.mutex.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatalf("等待超时: %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// messageCount 代理已收到的消息数，配合waitMessageSince只等待之后的消息
+func (h *harness) messageCount() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.messages)
+}
+
+// kickClients 代理主动断开插件的MQTT连接，插件应自动重连
+func (h *harness) kickClients() {
+	for _, cl := range h.broker.Clients.GetAll() {
+		if !cl.Net.Inline {
+			cl.Stop(fmt.Errorf("kicked by test"))
+		}
+	}
+}
+
+// waitStatus 等待网关的在线状态消息
+func (h *harness) waitStatus(t *testing.T, gatewayID string, status string) {
+	t.Helper()
+	topic := statusTopic + "/" + gatewayID
+	h.waitMessage(t, 10*time.Second, fmt.Sprintf("%s 状态 %s", gatewayID, status), func(msg message) bool {
+		return msg.Topic == topic && string(msg.Payload) == status
+	})
+}
+
+// telemetry 解析遥测消息，values为JSON序列化后的字节
+func telemetry(msg message) (string, map[string]interface{}, bool) {
+	if msg.Topic != telemetryTopic {
+		return "", nil, false
+	}
+	var payload struct {
+		DeviceID string `json:"device_id"`
+		Values   []byte `json:"values"`
+	}
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return "", nil, false
+	}
+	values := make(map[string]interface{})
+	if err := json.Unmarshal(payload.Values, &values); err != nil {
+		return "", nil, false
+	}
+	return payload.DeviceID, values, true
+}
+
+// waitTelemetry 等待子设备上报与expected一致的值
+func (h *harness) waitTelemetry(t *testing.T, subDeviceID string, expected map[string]float64) {
+	t.Helper()
+	h.waitMessage(t, 10*time.Second, fmt.Sprintf("%s 遥测 %v", subDeviceID, expected), func(msg message) bool {
+		deviceID, values, ok := telemetry(msg)
+		if !ok || deviceID != subDeviceID {
+			return false
+		}
+		for key, want := range expected {
+			got, ok := values[key].(float64)
+			if !ok || got != want {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+var regPkgSeq int64
+
+// uniqueRegPkg 每个测试使用不同的注册包，-count多次运行时也不重复
+func uniqueRegPkg(t *testing.T) string {
+	name := strings.NewReplacer("/", "-", " ", "-").Replace(t.Name())
+	return fmt.Sprintf("%s-%d", name, atomic.AddInt64(&regPkgSeq, 1))
+}
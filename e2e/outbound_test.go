@@ -0,0 +1,200 @@
+package e2e
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ThingsPanel/modbus-protocol-plugin/simulator"
+)
+
+// outboundRegPkg 主动连接（server.outbound）配置的注册包
+const outboundRegPkg = "outbound-plc"
+
+// certFiles 测试用证书文件
+type certFiles struct {
+	caFile         string
+	serverCertFile string
+	serverKeyFile  string
+	clientCertFile string
+	clientKeyFile  string
+}
+
+// generateCerts 生成CA、服务端证书（127.0.0.1）和客户端证书，写入dir
+func generateCerts(dir string) (*certFiles, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "e2e-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	issue := func(serial int64, cn string, usage x509.ExtKeyUsage, ips []net.IP) ([]byte, []byte, error) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  ips,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+	}
+	serverCert, serverKey, err := issue(2, "plc", x509.ExtKeyUsageServerAuth, []net.IP{net.ParseIP("127.0.0.1")})
+	if err != nil {
+		return nil, err
+	}
+	clientCert, clientKey, err := issue(3, "modbus-plugin", x509.ExtKeyUsageClientAuth, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	files := &certFiles{
+		caFile:         filepath.Join(dir, "ca.crt"),
+		serverCertFile: filepath.Join(dir, "server.crt"),
+		serverKeyFile:  filepath.Join(dir, "server.key"),
+		clientCertFile: filepath.Join(dir, "client.crt"),
+		clientKeyFile:  filepath.Join(dir, "client.key"),
+	}
+	for path, data := range map[string][]byte{
+		files.caFile:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		files.serverCertFile: serverCert,
+		files.serverKeyFile:  serverKey,
+		files.clientCertFile: clientCert,
+		files.clientKeyFile:  clientKey,
+	} {
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// startPLC 启动要求客户端证书的Modbus/TCP Security服务端，插件按server.outbound主动连接
+// 设置h.plc之前拒绝连接，插件按重连间隔重试
+func (h *harness) startPLC() error {
+	certs, err := generateCerts(h.dataDir)
+	if err != nil {
+		return err
+	}
+	h.certs = certs
+	serverCert, err := tls.LoadX509KeyPair(certs.serverCertFile, certs.serverKeyFile)
+	if err != nil {
+		return err
+	}
+	caPEM, err := os.ReadFile(certs.caFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caPEM)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		return err
+	}
+	h.plcListener = listener
+	h.plcAddr = listener.Addr().String()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go h.servePLC(conn.(*tls.Conn))
+		}
+	}()
+	return nil
+}
+
+// servePLC 完成握手并记录客户端证书CN，之后由模拟从站响应请求
+func (h *harness) servePLC(conn *tls.Conn) {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.Handshake(); err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	h.mutex.Lock()
+	plc := h.plc
+	if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+		h.plcClientCN = certs[0].Subject.CommonName
+	}
+	h.mutex.Unlock()
+	if plc == nil {
+		conn.Close()
+		return
+	}
+	plc.ServeConn(context.Background(), conn)
+}
+
+// TestOutboundModbusTCPSecurity 插件以客户端证书主动连接Modbus/TCP Security服务端并采集
+func TestOutboundModbusTCPSecurity(t *testing.T) {
+	subID := outboundRegPkg + "-sub1"
+	gatewayID := h.addGateway(outboundRegPkg, "MODBUS_TCP", subDevice(subID, 1, readCommand(0x03, 0, 1, "int16", "A1")))
+	plc, err := simulator.NewDTU(simulator.DTUConfig{
+		Framing:      simulator.FramingTCP,
+		Registration: outboundRegPkg,
+		Slaves:       []simulator.SlaveConfig{holdingSlave(1, 802)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.mutex.Lock()
+	h.plc = plc
+	h.mutex.Unlock()
+
+	h.waitStatus(t, gatewayID, "1")
+	h.waitTelemetry(t, subID, map[string]float64{"A1": 802})
+	h.mutex.Lock()
+	cn := h.plcClientCN
+	h.mutex.Unlock()
+	if cn != "modbus-plugin" {
+		t.Fatalf("服务端收到的客户端证书CN为%q，期望modbus-plugin", cn)
+	}
+}
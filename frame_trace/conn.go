@@ -0,0 +1,30 @@
+package frametrace
+
+import "net"
+
+// tracedConn 记录连接上所有读写的原始字节（包括心跳包和清空缓冲区时丢弃的数据）
+type tracedConn struct {
+	net.Conn
+	gatewayID string
+}
+
+// WrapConn 包装网关连接，网关开始抓包后记录收发的原始字节
+func WrapConn(conn net.Conn, gatewayID string) net.Conn {
+	return &tracedConn{Conn: conn, gatewayID: gatewayID}
+}
+
+func (c *tracedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		Capture(c.gatewayID, DirectionRX, p[:n])
+	}
+	return n, err
+}
+
+func (c *tracedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		Capture(c.gatewayID, DirectionTX, p[:n])
+	}
+	return n, err
+}
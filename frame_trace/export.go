@@ -0,0 +1,155 @@
+package frametrace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// WriteText 以文本格式输出抓包记录，每行一帧：时间 方向 子设备 十六进制数据
+func WriteText(w io.Writer, records []Record, status Status) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# gateway=%s sub_device=%s protocol=%s started=%s until=%s records=%d dropped=%d\n",
+		status.GatewayID, status.SubDeviceID, status.Protocol,
+		status.StartedAt.Format(time.RFC3339), status.Until.Format(time.RFC3339), status.Records, status.Dropped)
+	for _, record := range records {
+		subDevice := record.SubDeviceID
+		if subDevice == "" {
+			subDevice = "-"
+		}
+		fmt.Fprintf(bw, "%s %s %s % X\n", record.Time.Format("2006-01-02T15:04:05.000000Z07:00"), record.Direction, subDevice, record.Data)
+	}
+	return bw.Flush()
+}
+
+// 伪TCP封装的地址：插件作为Modbus主站从50000端口连接网关的502端口
+var (
+	pluginAddr  = [4]byte{10, 0, 0, 1}
+	gatewayAddr = [4]byte{10, 0, 0, 2}
+)
+
+const (
+	pluginPort  = 50000
+	gatewayPort = 502
+	// pcap每条记录的最大数据长度，超出部分拆分为多个报文
+	maxSegment = 65535 - 40
+)
+
+// WritePcap 以pcap格式输出抓包记录，每次读写封装为一个IPv4/TCP报文（LINKTYPE_RAW）
+// TX为插件(10.0.0.1:50000)发往网关(10.0.0.2:502)，RX方向相反
+// Modbus TCP可直接由Wireshark解析，Modbus RTU需在Wireshark中将502端口解码为Modbus/RTU
+func WritePcap(w io.Writer, records []Record) error {
+	bw := bufio.NewWriter(w)
+	// 全局头：微秒时间戳，版本2.4，快照长度65535，链路类型101（原始IP）
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], 65535)
+	binary.LittleEndian.PutUint32(header[20:24], 101)
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+
+	// 双方的TCP序号，按发送的字节数递增，Wireshark据此重组被拆分的帧
+	txSeq, rxSeq := uint32(1), uint32(1)
+	var ipID uint16
+	for _, record := range records {
+		for data := record.Data; len(data) > 0; {
+			segment := data
+			if len(segment) > maxSegment {
+				segment = segment[:maxSegment]
+			}
+			data = data[len(segment):]
+
+			ipID++
+			var packet []byte
+			if record.Direction == DirectionTX {
+				packet = tcpPacket(pluginAddr, gatewayAddr, pluginPort, gatewayPort, txSeq, rxSeq, ipID, segment)
+				txSeq += uint32(len(segment))
+			} else {
+				packet = tcpPacket(gatewayAddr, pluginAddr, gatewayPort, pluginPort, rxSeq, txSeq, ipID, segment)
+				rxSeq += uint32(len(segment))
+			}
+
+			recordHeader := make([]byte, 16)
+			binary.LittleEndian.PutUint32(recordHeader[0:4], uint32(record.Time.Unix()))
+			binary.LittleEndian.PutUint32(recordHeader[4:8], uint32(record.Time.Nanosecond()/1000))
+			binary.LittleEndian.PutUint32(recordHeader[8:12], uint32(len(packet)))
+			binary.LittleEndian.PutUint32(recordHeader[12:16], uint32(len(packet)))
+			if _, err := bw.Write(recordHeader); err != nil {
+				return err
+			}
+			if _, err := bw.Write(packet); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// tcpPacket 构造携带数据的IPv4/TCP报文（PSH|ACK）
+func tcpPacket(src, dst [4]byte, srcPort, dstPort uint16, seq, ack uint32, ipID uint16, payload []byte) []byte {
+	packet := make([]byte, 40+len(payload))
+
+	// IPv4头
+	ip := packet[:20]
+	ip[0] = 0x45 // 版本4，头长度20字节
+	binary.BigEndian.PutUint16(ip[2:4], uint16(len(packet)))
+	binary.BigEndian.PutUint16(ip[4:6], ipID)
+	ip[6] = 0x40 // 不分片
+	ip[8] = 64   // TTL
+	ip[9] = 6    // TCP
+	copy(ip[12:16], src[:])
+	copy(ip[16:20], dst[:])
+	binary.BigEndian.PutUint16(ip[10:12], checksum(ip, 0))
+
+	// TCP头
+	tcp := packet[20:]
+	binary.BigEndian.PutUint16(tcp[0:2], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:4], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:8], seq)
+	binary.BigEndian.PutUint32(tcp[8:12], ack)
+	tcp[12] = 5 << 4 // 头长度20字节
+	tcp[13] = 0x18   // PSH|ACK
+	binary.BigEndian.PutUint16(tcp[14:16], 65535)
+	copy(tcp[20:], payload)
+
+	// TCP校验和包含伪首部
+	pseudo := uint32(src[0])<<8 | uint32(src[1])
+	pseudo += uint32(src[2])<<8 | uint32(src[3])
+	pseudo += uint32(dst[0])<<8 | uint32(dst[1])
+	pseudo += uint32(dst[2])<<8 | uint32(dst[3])
+	pseudo += 6 + uint32(len(tcp))
+	binary.BigEndian.PutUint16(tcp[16:18], checksum(tcp, pseudo))
+	return packet
+}
+
+// checksum 计算互联网校验和，initial为已累加的部分和
+func checksum(data []byte, initial uint32) uint16 {
+	sum := initial
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+// FileName 下载文件名
+func FileName(gatewayID string, format string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == '"' || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, gatewayID)
+	return fmt.Sprintf("trace-%s-%s.%s", name, time.Now().Format("20060102-150405"), format)
+}
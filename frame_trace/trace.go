@@ -0,0 +1,216 @@
+package frametrace
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 帧方向
+const (
+	DirectionTX = "TX" // 插件发往网关
+	DirectionRX = "RX" // 网关发往插件
+)
+
+// 抓包默认值和上限
+const (
+	DefaultDuration = 10 * time.Minute
+	MaxDuration     = 60 * time.Minute
+	DefaultCapacity = 10000
+	MaxCapacity     = 100000
+)
+
+// Record 一次读写的原始字节
+type Record struct {
+	Time        time.Time
+	Direction   string
+	SubDeviceID string // 发生读写时正在通信的子设备，未知时为空
+	Data        []byte
+}
+
+// trace 一个网关的抓包任务，记录保存在环形缓冲区中，缓冲区满时覆盖最早的记录
+type trace struct {
+	mutex       sync.Mutex
+	gatewayID   string
+	subDeviceID string // 只记录该子设备的通信，为空记录网关的全部通信
+	protocol    string
+	startedAt   time.Time
+	until       time.Time
+	stopped     bool
+	current     string // 当前正在通信的子设备
+	records     []Record
+	next        int   // 下一条记录写入的位置
+	full        bool  // 缓冲区是否已写满
+	dropped     int64 // 被覆盖的记录数
+}
+
+// 网关ID -> *trace
+var traces sync.Map
+
+// Status 抓包任务状态
+type Status struct {
+	GatewayID   string    `json:"gateway_id"`
+	SubDeviceID string    `json:"sub_device_id,omitempty"`
+	Protocol    string    `json:"protocol"`
+	Active      bool      `json:"active"`
+	StartedAt   time.Time `json:"started_at"`
+	Until       time.Time `json:"until"`
+	Records     int       `json:"records"`
+	Capacity    int       `json:"capacity"`
+	Dropped     int64     `json:"dropped"`
+}
+
+// Start 开始抓取网关的收发帧，duration后自动停止，同一网关已有的抓包记录被清除
+// subDeviceID不为空时只记录与该子设备通信期间的帧
+func Start(gatewayID string, subDeviceID string, protocol string, duration time.Duration, capacity int) Status {
+	if duration <= 0 {
+		duration = DefaultDuration
+	}
+	if duration > MaxDuration {
+		duration = MaxDuration
+	}
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	if capacity > MaxCapacity {
+		capacity = MaxCapacity
+	}
+	now := time.Now()
+	t := &trace{
+		gatewayID:   gatewayID,
+		subDeviceID: subDeviceID,
+		protocol:    protocol,
+		startedAt:   now,
+		until:       now.Add(duration),
+		records:     make([]Record, capacity),
+	}
+	traces.Store(gatewayID, t)
+	return t.status()
+}
+
+// Stop 停止抓包，已抓取的记录保留到下次开始或删除
+func Stop(gatewayID string) bool {
+	v, ok := traces.Load(gatewayID)
+	if !ok {
+		return false
+	}
+	t := v.(*trace)
+	t.mutex.Lock()
+	t.stopped = true
+	t.mutex.Unlock()
+	return true
+}
+
+// Delete 删除网关的抓包任务和记录
+func Delete(gatewayID string) bool {
+	_, ok := traces.LoadAndDelete(gatewayID)
+	return ok
+}
+
+// Statuses 所有抓包任务的状态
+func Statuses() []Status {
+	statuses := make([]Status, 0)
+	traces.Range(func(key, value interface{}) bool {
+		statuses = append(statuses, value.(*trace).status())
+		return true
+	})
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].GatewayID < statuses[j].GatewayID })
+	return statuses
+}
+
+// SetSubDevice 标记网关当前正在通信的子设备，调用方需持有网关的设备锁
+func SetSubDevice(gatewayID string, subDeviceID string) {
+	v, ok := traces.Load(gatewayID)
+	if !ok {
+		return
+	}
+	t := v.(*trace)
+	t.mutex.Lock()
+	t.current = subDeviceID
+	t.mutex.Unlock()
+}
+
+// Capture 记录网关连接上的一次读写，网关没有进行中的抓包任务时直接返回
+func Capture(gatewayID string, direction string, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	v, ok := traces.Load(gatewayID)
+	if !ok {
+		return
+	}
+	v.(*trace).append(direction, data)
+}
+
+func (t *trace) activeLocked(now time.Time) bool {
+	return !t.stopped && now.Before(t.until)
+}
+
+func (t *trace) append(direction string, data []byte) {
+	now := time.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !t.activeLocked(now) {
+		return
+	}
+	if t.subDeviceID != "" && t.current != t.subDeviceID {
+		return
+	}
+	if t.full {
+		t.dropped++
+	}
+	t.records[t.next] = Record{
+		Time:        now,
+		Direction:   direction,
+		SubDeviceID: t.current,
+		Data:        append([]byte(nil), data...),
+	}
+	t.next++
+	if t.next == len(t.records) {
+		t.next = 0
+		t.full = true
+	}
+}
+
+func (t *trace) status() Status {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	count := t.next
+	if t.full {
+		count = len(t.records)
+	}
+	return Status{
+		GatewayID:   t.gatewayID,
+		SubDeviceID: t.subDeviceID,
+		Protocol:    t.protocol,
+		Active:      t.activeLocked(time.Now()),
+		StartedAt:   t.startedAt,
+		Until:       t.until,
+		Records:     count,
+		Capacity:    len(t.records),
+		Dropped:     t.dropped,
+	}
+}
+
+// snapshot 按时间顺序复制缓冲区中的记录
+func (t *trace) snapshot() []Record {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !t.full {
+		return append([]Record(nil), t.records[:t.next]...)
+	}
+	records := make([]Record, 0, len(t.records))
+	records = append(records, t.records[t.next:]...)
+	return append(records, t.records[:t.next]...)
+}
+
+// Records 网关抓包记录，按时间顺序
+func Records(gatewayID string) ([]Record, Status, error) {
+	v, ok := traces.Load(gatewayID)
+	if !ok {
+		return nil, Status{}, fmt.Errorf("网关没有抓包记录: %s", gatewayID)
+	}
+	t := v.(*trace)
+	return t.snapshot(), t.status(), nil
+}
@@ -1,6 +1,7 @@
 package globaldata
 
 import (
+	"bytes"
 	"encoding/json"
 	"strings"
 	"sync"
@@ -21,9 +22,62 @@ var SubDeviceIDAndGateWayIDMap sync.Map
 // var DeviceConnectionMap = make(map[string]*net.Conn)
 var DeviceConnectionMap sync.Map
 
-// 设备读写互斥锁
+// 网关原始注册包，key是网关ID，value是[]byte
+var GatewayRegPacketMap sync.Map
+
+// 设备读写互斥锁，通过GetDeviceLock获取
 var DeviceRWLock = map[string]*sync.Mutex{}
 
+// 保护DeviceRWLock的并发读写
+var deviceRWLockMutex sync.Mutex
+
+// GetDeviceLock 获取设备的读写互斥锁，不存在则创建
+func GetDeviceLock(regPkg string) *sync.Mutex {
+	deviceRWLockMutex.Lock()
+	defer deviceRWLockMutex.Unlock()
+	lock, ok := DeviceRWLock[regPkg]
+	if !ok {
+		lock = &sync.Mutex{}
+		DeviceRWLock[regPkg] = lock
+	}
+	return lock
+}
+
+// GetFramePrefix 网关开启帧前缀模式（网关配置prefixed_frames）时返回每帧前的前缀，即原始注册包；未开启返回nil
+// 每次读取最新的网关配置，平台修改网关配置后立即生效
+func GetFramePrefix(gatewayID string) []byte {
+	m, ok := GateWayConfigMap.Load(gatewayID)
+	if !ok || !isTrue(m.(*api.DeviceConfigResponseData).Config["prefixed_frames"]) {
+		return nil
+	}
+	if raw, ok := GatewayRegPacketMap.Load(gatewayID); ok {
+		return raw.([]byte)
+	}
+	return nil
+}
+
+// TrimFramePrefix 剔除一次读取得到的数据块开头的帧前缀
+// 前缀只出现在DTU每包数据之前，只在数据块开头剔除一次，不在数据中间查找，寄存器或线圈数据中与前缀相同的字节不受影响
+func TrimFramePrefix(chunk []byte, framePrefix []byte) []byte {
+	if len(framePrefix) == 0 {
+		return chunk
+	}
+	return bytes.TrimPrefix(chunk, framePrefix)
+}
+
+// isTrue 平台表单的开关值可能是bool、数字或字符串
+func isTrue(v interface{}) bool {
+	switch value := v.(type) {
+	case bool:
+		return value
+	case float64:
+		return value != 0
+	case string:
+		return value == "true" || value == "1"
+	}
+	return false
+}
+
 // modbus错误码映射
 var ModbusErrorMap = map[byte]string{
 	0x01: "Illegal function",
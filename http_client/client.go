@@ -1,10 +1,12 @@
 package httpclient
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
+	serverconfig "github.com/ThingsPanel/modbus-protocol-plugin/server_config"
 	tpprotocolsdkgo "github.com/ThingsPanel/tp-protocol-sdk-go"
 	"github.com/ThingsPanel/tp-protocol-sdk-go/api"
 	"github.com/sirupsen/logrus"
@@ -13,12 +15,18 @@ import (
 
 var client *tpprotocolsdkgo.Client
 
+// ErrPlatformUnavailable 平台接口不可达（网络错误、HTTP状态码异常等）
+var ErrPlatformUnavailable = errors.New("平台接口不可用")
+
 func Init() {
 	addr := viper.GetString("thingspanel.address")
 	logrus.Info("创建http客户端:", addr)
 	client = tpprotocolsdkgo.NewClient(addr)
-	go ServiceHeartbeat1()
-	go ServiceHeartbeat2()
+	// 每个服务标识符单独上报心跳
+	for _, sid := range serverconfig.Identifiers() {
+		logrus.Info("服务心跳上报：", sid)
+		go ServiceHeartbeat(sid)
+	}
 }
 
 func GetDeviceConfig(voucher string, deviceID string) (*api.DeviceConfigResponse, error) {
@@ -27,10 +35,11 @@ func GetDeviceConfig(voucher string, deviceID string) (*api.DeviceConfigResponse
 		DeviceID: deviceID,
 	}
 	response, err := client.API.GetDeviceConfig(deviceConfigReq)
+	recordPlatformCall(err)
 	if err != nil {
 		errMsg := fmt.Sprintf("获取设备配置失败 (请求参数： %+v): %v", deviceConfigReq, err)
 		logrus.Info(errMsg)
-		return nil, fmt.Errorf(errMsg)
+		return nil, fmt.Errorf("%s: %w", errMsg, ErrPlatformUnavailable)
 	}
 	if response.Code != 200 {
 		errMsg := fmt.Sprintf("获取设备配置失败 (请求参数： %+v): %v", deviceConfigReq, response.Message)
@@ -39,34 +48,11 @@ func GetDeviceConfig(voucher string, deviceID string) (*api.DeviceConfigResponse
 	return response, nil
 }
 
-func ServiceHeartbeat1() {
-	for {
-		err := reportHeartbeat1()
-		if err != nil {
-			log.Println(err)
-		}
-		time.Sleep(50 * time.Second)
-	}
-}
-
-// 这里需要改为自己的服务
-func reportHeartbeat1() error {
-	sid := viper.GetString("server.identifier1")
-	serviceHeartbeatReq := api.HeartbeatRequest{
-		ServiceIdentifier: sid,
-	}
-	response, err := client.API.Heartbeat(serviceHeartbeatReq)
-	if err != nil {
-		return fmt.Errorf("服务心跳上报失败 (请求参数：%+v): %v", serviceHeartbeatReq, err)
-	}
-	if response.Code != 200 {
-		return fmt.Errorf("服务心跳上报失败 (请求参数：%+v): %v", serviceHeartbeatReq, response.Message)
-	}
-	return nil
-}
-func ServiceHeartbeat2() {
+// ServiceHeartbeat 定期上报服务心跳
+func ServiceHeartbeat(sid string) {
 	for {
-		err := reportHeartbeat2()
+		err := reportHeartbeat(sid)
+		recordHeartbeat(sid, err)
 		if err != nil {
 			log.Println(err)
 		}
@@ -74,13 +60,13 @@ func ServiceHeartbeat2() {
 	}
 }
 
-// 这里需要改为自己的服务
-func reportHeartbeat2() error {
-	sid := viper.GetString("server.identifier2")
+// reportHeartbeat 上报一次服务心跳
+func reportHeartbeat(sid string) error {
 	serviceHeartbeatReq := api.HeartbeatRequest{
 		ServiceIdentifier: sid,
 	}
 	response, err := client.API.Heartbeat(serviceHeartbeatReq)
+	recordPlatformCall(err)
 	if err != nil {
 		return fmt.Errorf("服务心跳上报失败 (请求参数：%+v): %v", serviceHeartbeatReq, err)
 	}
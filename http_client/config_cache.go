@@ -0,0 +1,117 @@
+package httpclient
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ThingsPanel/tp-protocol-sdk-go/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// cachedConfig 本地缓存的网关配置
+type cachedConfig struct {
+	Voucher  string                   `json:"voucher"`
+	SavedAt  time.Time                `json:"saved_at"`
+	Response api.DeviceConfigResponse `json:"response"`
+}
+
+// GetDeviceConfigWithCache 通过凭证获取网关配置，平台不可用时使用本地缓存
+// 返回的fromCache为true表示配置来自缓存，调用方应在平台恢复后刷新配置
+func GetDeviceConfigWithCache(voucher string) (*api.DeviceConfigResponse, bool, error) {
+	response, err := GetDeviceConfig(voucher, "")
+	if err == nil {
+		saveConfigCache(voucher, response)
+		return response, false, nil
+	}
+	// 平台明确拒绝（凭证错误等）时不使用缓存
+	if !errors.Is(err, ErrPlatformUnavailable) || !configCacheEnabled() {
+		return nil, false, err
+	}
+
+	cached, ok := loadConfigCache(voucher)
+	if !ok {
+		return nil, false, err
+	}
+	maxAge := configCacheMaxAge()
+	if age := time.Since(cached.SavedAt); age > maxAge {
+		logrus.Warnf("平台不可用，本地缓存配置已过期: age=%v, max_age=%v", age.Round(time.Second), maxAge)
+		return nil, false, err
+	}
+	logrus.Warnf("平台不可用，使用本地缓存的网关配置: gatewayID=%s, saved_at=%s", cached.Response.Data.ID, cached.SavedAt.Format(time.RFC3339))
+	return &cached.Response, true, nil
+}
+
+func configCacheEnabled() bool {
+	return viper.GetBool("config_cache.enabled")
+}
+
+func configCacheMaxAge() time.Duration {
+	maxAge := viper.GetDuration("config_cache.max_age")
+	if maxAge <= 0 {
+		maxAge = 72 * time.Hour // 默认3天
+	}
+	return maxAge
+}
+
+// configCachePath 缓存文件路径，文件名为凭证的sha256
+func configCachePath(voucher string) string {
+	dir := viper.GetString("config_cache.dir")
+	if dir == "" {
+		dir = "./data/config_cache"
+	}
+	sum := sha256.Sum256([]byte(voucher))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func saveConfigCache(voucher string, response *api.DeviceConfigResponse) {
+	if !configCacheEnabled() {
+		return
+	}
+	data, err := json.Marshal(&cachedConfig{
+		Voucher:  voucher,
+		SavedAt:  time.Now(),
+		Response: *response,
+	})
+	if err != nil {
+		logrus.Warnf("序列化网关配置缓存失败: %v", err)
+		return
+	}
+
+	path := configCachePath(voucher)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logrus.Warnf("创建网关配置缓存目录失败: %v", err)
+		return
+	}
+	// 先写临时文件再重命名，避免写一半
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		logrus.Warnf("写入网关配置缓存失败: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		logrus.Warnf("写入网关配置缓存失败: %v", err)
+	}
+}
+
+func loadConfigCache(voucher string) (*cachedConfig, bool) {
+	data, err := os.ReadFile(configCachePath(voucher))
+	if err != nil {
+		return nil, false
+	}
+	var cached cachedConfig
+	if err := json.Unmarshal(data, &cached); err != nil {
+		logrus.Warnf("解析网关配置缓存失败: %v", err)
+		return nil, false
+	}
+	// 防止哈希碰撞或文件被替换
+	if cached.Voucher != voucher {
+		return nil, false
+	}
+	return &cached, true
+}
@@ -0,0 +1,71 @@
+package httpclient
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 平台接口调用状态，供健康检查查询
+var (
+	statusMutex         sync.Mutex
+	lastPlatformSuccess time.Time
+	lastPlatformError   string
+	heartbeatStatuses   = make(map[string]*HeartbeatStatus)
+)
+
+// HeartbeatStatus 服务心跳上报状态
+type HeartbeatStatus struct {
+	Identifier  string     `json:"identifier"`
+	LastSuccess *time.Time `json:"last_success,omitempty"` // 尚未成功上报时为空
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// recordPlatformCall 记录一次平台接口调用结果，平台返回错误码也说明接口可达
+func recordPlatformCall(err error) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	if err != nil {
+		lastPlatformError = err.Error()
+		return
+	}
+	lastPlatformSuccess = time.Now()
+	lastPlatformError = ""
+}
+
+// recordHeartbeat 记录一次服务心跳上报结果
+func recordHeartbeat(sid string, err error) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	status, ok := heartbeatStatuses[sid]
+	if !ok {
+		status = &HeartbeatStatus{Identifier: sid}
+		heartbeatStatuses[sid] = status
+	}
+	if err != nil {
+		status.LastError = err.Error()
+		return
+	}
+	now := time.Now()
+	status.LastSuccess = &now
+	status.LastError = ""
+}
+
+// PlatformStatus 最近一次成功调用平台接口的时间（从未成功时为零值）和最近一次失败原因
+func PlatformStatus() (time.Time, string) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	return lastPlatformSuccess, lastPlatformError
+}
+
+// HeartbeatStatuses 各服务标识符的心跳上报状态
+func HeartbeatStatuses() []HeartbeatStatus {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	statuses := make([]HeartbeatStatus, 0, len(heartbeatStatuses))
+	for _, status := range heartbeatStatuses {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Identifier < statuses[j].Identifier })
+	return statuses
+}
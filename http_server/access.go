@@ -0,0 +1,39 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+
+	service "github.com/ThingsPanel/modbus-protocol-plugin/services"
+	"github.com/sirupsen/logrus"
+)
+
+// OnListBans 查询当前生效的IP封禁
+func OnListBans(w http.ResponseWriter, r *http.Request) {
+	RspSuccess(w, service.Bans())
+}
+
+// OnUnbanIP 解除IP封禁，请求体：{"ip":"1.2.3.4"}
+func OnUnbanIP(w http.ResponseWriter, r *http.Request) {
+	logrus.Info("【收到api请求】path", r.URL.Path)
+	defer r.Body.Close()
+	var req struct {
+		IP string `json:"ip"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RspError(w, errors.New("invalid request body"))
+		return
+	}
+	ip := req.IP
+	if net.ParseIP(ip) == nil {
+		RspError(w, errors.New("invalid ip"))
+		return
+	}
+	if !service.UnbanIP(ip) {
+		RspError(w, errors.New("ip not banned"))
+		return
+	}
+	RspSuccess(w, nil)
+}
@@ -0,0 +1,28 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+
+	service "github.com/ThingsPanel/modbus-protocol-plugin/services"
+)
+
+// OnListGateways 查询已连接的网关
+func OnListGateways(w http.ResponseWriter, r *http.Request) {
+	RspSuccess(w, service.ListGateways())
+}
+
+// OnGetGateway 查询单个网关的子设备和采集任务状态，参数：?id=网关ID
+func OnGetGateway(w http.ResponseWriter, r *http.Request) {
+	gatewayID := r.URL.Query().Get("id")
+	if gatewayID == "" {
+		RspError(w, errors.New("id is required"))
+		return
+	}
+	gateway, ok := service.GetGateway(gatewayID)
+	if !ok {
+		RspError(w, errors.New("gateway not connected"))
+		return
+	}
+	RspSuccess(w, gateway)
+}
@@ -0,0 +1,34 @@
+package httpserver
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// adminToken 运维接口的管理令牌（http_server.admin_token）
+func adminToken() string {
+	return viper.GetString("http_server.admin_token")
+}
+
+// requireAdminToken 运维接口（会修改状态或暴露调试数据）需要管理令牌，请求头为 Authorization: Bearer <token>
+// 这些接口与平台回调共用监听地址，未配置令牌时关闭
+func requireAdminToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := adminToken()
+		if token == "" {
+			http.Error(w, "admin api disabled: http_server.admin_token not configured", http.StatusForbidden)
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			logrus.Warnf("运维接口认证失败: path=%s, remote=%s", r.URL.Path, r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,60 @@
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// TestAdminTokenRequired 运维接口需要管理令牌，未配置令牌时关闭；平台回调和健康检查不受影响
+func TestAdminTokenRequired(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	mux := newServeMux()
+	request := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	defer viper.Set("http_server.admin_token", nil)
+	viper.Set("http_server.admin_token", "")
+	if code := request(http.MethodPost, "/api/v1/access/unban", ""); code != http.StatusForbidden {
+		t.Fatalf("未配置令牌时返回%d，期望403", code)
+	}
+
+	viper.Set("http_server.admin_token", "secret")
+	cases := []struct {
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{http.MethodPost, "/api/v1/access/unban", "", http.StatusUnauthorized},
+		{http.MethodPost, "/api/v1/trace/start", "wrong", http.StatusUnauthorized},
+		{http.MethodPost, "/api/v1/scan/start", "", http.StatusUnauthorized},
+		{http.MethodGet, "/api/v1/admin/gateways", "", http.StatusUnauthorized},
+		{http.MethodGet, "/api/v1/admin/gateways", "secret", http.StatusOK},
+		{http.MethodGet, "/api/v1/scan/list", "secret", http.StatusOK},
+		{http.MethodGet, "/healthz", "", 0},
+	}
+	for _, c := range cases {
+		code := request(c.method, c.path, c.token)
+		if c.want == 0 {
+			if code == http.StatusUnauthorized || code == http.StatusForbidden {
+				t.Errorf("%s 不应需要令牌，返回%d", c.path, code)
+			}
+			continue
+		}
+		if code != c.want {
+			t.Errorf("%s %s token=%q 返回%d，期望%d", c.method, c.path, c.token, code, c.want)
+		}
+	}
+}
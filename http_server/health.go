@@ -0,0 +1,139 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	httpclient "github.com/ThingsPanel/modbus-protocol-plugin/http_client"
+	mqtt "github.com/ThingsPanel/modbus-protocol-plugin/mqtt"
+	serverconfig "github.com/ThingsPanel/modbus-protocol-plugin/server_config"
+	service "github.com/ThingsPanel/modbus-protocol-plugin/services"
+	"github.com/spf13/viper"
+)
+
+// HealthCheck 单项检查结果
+type HealthCheck struct {
+	OK     bool        `json:"ok"`
+	Detail interface{} `json:"detail,omitempty"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+// HealthReport 健康检查结果
+type HealthReport struct {
+	Status string                 `json:"status"` // ok 或 fail
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+// healthDuration 读取健康检查的时间配置
+func healthDuration(key string, defaultValue time.Duration) time.Duration {
+	if d := viper.GetDuration(key); d > 0 {
+		return d
+	}
+	return defaultValue
+}
+
+// OnHealthz 存活检查：监听器启动失败或MQTT长时间断开时返回503，编排系统据此重启实例
+func OnHealthz(w http.ResponseWriter, r *http.Request) {
+	mqttMaxDown := healthDuration("health.mqtt_max_down", 5*time.Minute)
+	writeHealthReport(w, map[string]HealthCheck{
+		"mqtt":      mqttCheck(mqttMaxDown),
+		"listeners": listenersCheck(false),
+	})
+}
+
+// OnReadyz 就绪检查：MQTT已连接、所有监听器正在监听、平台接口和服务心跳在有效期内成功时返回200
+func OnReadyz(w http.ResponseWriter, r *http.Request) {
+	maxAge := healthDuration("health.platform_max_age", 3*time.Minute)
+	writeHealthReport(w, map[string]HealthCheck{
+		"mqtt":      mqttCheck(0),
+		"listeners": listenersCheck(true),
+		"platform":  platformCheck(maxAge),
+		"heartbeat": heartbeatCheck(maxAge),
+	})
+}
+
+func writeHealthReport(w http.ResponseWriter, checks map[string]HealthCheck) {
+	report := HealthReport{Status: "ok", Checks: checks}
+	code := http.StatusOK
+	for _, check := range checks {
+		if !check.OK {
+			report.Status = "fail"
+			code = http.StatusServiceUnavailable
+			break
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
+
+// mqttCheck MQTT连接状态，断开时间不超过maxDown时仍视为正常（0表示断开即异常）
+func mqttCheck(maxDown time.Duration) HealthCheck {
+	connected, since := mqtt.ConnectionState()
+	detail := map[string]interface{}{"connected": connected}
+	if connected {
+		return HealthCheck{OK: true, Detail: detail}
+	}
+	if !since.IsZero() {
+		detail["disconnected_since"] = since
+	}
+	if maxDown > 0 && !since.IsZero() && time.Since(since) < maxDown {
+		return HealthCheck{OK: true, Detail: detail}
+	}
+	return HealthCheck{Detail: detail, Reason: "mqtt disconnected"}
+}
+
+// listenersCheck 监听器状态，启动失败的监听器始终异常，requireListening时启动中也视为未就绪
+func listenersCheck(requireListening bool) HealthCheck {
+	statuses := service.ListenerStatuses()
+	check := HealthCheck{OK: true, Detail: statuses}
+	if len(statuses) == 0 && requireListening {
+		check.OK = false
+		check.Reason = "no listener started"
+	}
+	for _, status := range statuses {
+		if status.State == service.ListenerFailed || (requireListening && status.State != service.ListenerListening) {
+			check.OK = false
+			check.Reason = fmt.Sprintf("listener %s %s", status.Name, status.State)
+			break
+		}
+	}
+	return check
+}
+
+// platformCheck 最近一次成功调用平台接口是否在有效期内
+func platformCheck(maxAge time.Duration) HealthCheck {
+	lastSuccess, lastError := httpclient.PlatformStatus()
+	detail := map[string]interface{}{}
+	if !lastSuccess.IsZero() {
+		detail["last_success"] = lastSuccess
+	}
+	if lastError != "" {
+		detail["last_error"] = lastError
+	}
+	if lastSuccess.IsZero() || time.Since(lastSuccess) > maxAge {
+		return HealthCheck{Detail: detail, Reason: "no successful platform api call within " + maxAge.String()}
+	}
+	return HealthCheck{OK: true, Detail: detail}
+}
+
+// heartbeatCheck 每个服务标识符的心跳是否在有效期内上报成功
+func heartbeatCheck(maxAge time.Duration) HealthCheck {
+	statuses := httpclient.HeartbeatStatuses()
+	byIdentifier := make(map[string]httpclient.HeartbeatStatus, len(statuses))
+	for _, status := range statuses {
+		byIdentifier[status.Identifier] = status
+	}
+	check := HealthCheck{OK: true, Detail: statuses}
+	for _, sid := range serverconfig.Identifiers() {
+		status, ok := byIdentifier[sid]
+		if !ok || status.LastSuccess == nil || time.Since(*status.LastSuccess) > maxAge {
+			check.OK = false
+			check.Reason = "heartbeat " + sid + " not reported within " + maxAge.String()
+			break
+		}
+	}
+	return check
+}
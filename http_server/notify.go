@@ -0,0 +1,67 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	service "github.com/ThingsPanel/modbus-protocol-plugin/services"
+	"github.com/sirupsen/logrus"
+)
+
+// 平台通知事件的消息类型
+const (
+	NotifyServiceConfigChanged = "1" // 服务配置修改
+)
+
+// NotifyEvent 平台通知事件的请求体，message为消息内容（字符串）
+type NotifyEvent struct {
+	MessageType string `json:"message_type"`
+	Message     string `json:"message"`
+}
+
+// reloadMutex 串行执行配置重新加载，连续收到多个通知时依次处理
+var reloadMutex sync.Mutex
+
+// OnNotifyEvent 平台通知事件
+// 配置修改时消息内容不指明具体设备，重新获取所有已连接网关的配置，只重启变化的子设备采集任务，不断开网关连接；
+// 重新加载需要逐个请求平台，在后台执行，立即应答平台的回调
+// 删除网关由平台调用 /api/v1/device/disconnect 断开
+func OnNotifyEvent(w http.ResponseWriter, r *http.Request) {
+	logrus.Info("【收到api请求】path", r.URL.Path)
+	defer r.Body.Close()
+	var event NotifyEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		RspError(w, errors.New("invalid request body"))
+		return
+	}
+	logrus.Infof("平台通知事件: message_type=%s, message=%s", event.MessageType, event.Message)
+
+	switch event.MessageType {
+	case NotifyServiceConfigChanged:
+		go func() {
+			reloaded, failed := reloadConnectedGateways()
+			logrus.Infof("配置修改通知处理完成: 重新加载网关=%d, 失败=%d", reloaded, failed)
+		}()
+	default:
+		logrus.Infof("忽略未处理的通知事件类型: %s", event.MessageType)
+	}
+	RspSuccess(w, nil)
+}
+
+// reloadConnectedGateways 重新获取所有已连接网关的配置并应用
+// 单个网关失败不影响其他网关，失败的网关保持原配置，下次重连时重新获取
+func reloadConnectedGateways() (reloaded int, failed int) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	for _, gatewayID := range service.ConnectedGatewayIDs() {
+		if err := updateGatewayConfig(gatewayID); err != nil {
+			logrus.Errorf("重新加载网关配置失败: gatewayID=%s, error=%v", gatewayID, err)
+			failed++
+			continue
+		}
+		reloaded++
+	}
+	return reloaded, failed
+}
@@ -0,0 +1,65 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	service "github.com/ThingsPanel/modbus-protocol-plugin/services"
+	"github.com/sirupsen/logrus"
+)
+
+// OnStartScan 开始扫描网关总线上的从站和地址范围
+// 请求体：{"gateway_id":"", "slave_from":1, "slave_to":247, "slave_ids":[], "ranges":[{"function_code":3,"start":0,"end":99}], "timeout_ms":500, "block_size":16}
+func OnStartScan(w http.ResponseWriter, r *http.Request) {
+	logrus.Info("【收到api请求】path", r.URL.Path)
+	defer r.Body.Close()
+	var req service.ScanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RspError(w, errors.New("invalid request body"))
+		return
+	}
+	if req.GatewayID == "" {
+		RspError(w, errors.New("gateway_id is required"))
+		return
+	}
+	result, err := service.StartScan(req)
+	if err != nil {
+		RspError(w, err)
+		return
+	}
+	RspSuccess(w, result)
+}
+
+// OnCancelScan 取消扫描，请求体：{"gateway_id":""}
+func OnCancelScan(w http.ResponseWriter, r *http.Request) {
+	logrus.Info("【收到api请求】path", r.URL.Path)
+	defer r.Body.Close()
+	var req struct {
+		GatewayID string `json:"gateway_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RspError(w, errors.New("invalid request body"))
+		return
+	}
+	if !service.CancelScan(req.GatewayID) {
+		RspError(w, errors.New("scan not running"))
+		return
+	}
+	RspSuccess(w, nil)
+}
+
+// OnGetScan 查询网关最近一次扫描的进度和结果，参数：?gateway_id=网关ID
+func OnGetScan(w http.ResponseWriter, r *http.Request) {
+	result, ok := service.ScanStatus(r.URL.Query().Get("gateway_id"))
+	if !ok {
+		RspError(w, errors.New("scan not found"))
+		return
+	}
+	RspSuccess(w, result)
+}
+
+// OnListScans 查询所有网关最近一次扫描的进度和结果
+func OnListScans(w http.ResponseWriter, r *http.Request) {
+	RspSuccess(w, service.ScanStatuses())
+}
@@ -9,6 +9,8 @@ import (
 	"os"
 
 	globaldata "github.com/ThingsPanel/modbus-protocol-plugin/global_data"
+	httpclient "github.com/ThingsPanel/modbus-protocol-plugin/http_client"
+	"github.com/ThingsPanel/modbus-protocol-plugin/metrics"
 	service "github.com/ThingsPanel/modbus-protocol-plugin/services"
 	tpprotocolsdkgo "github.com/ThingsPanel/tp-protocol-sdk-go"
 	"github.com/sirupsen/logrus"
@@ -22,22 +24,63 @@ func Init() {
 }
 
 func start() {
-	var handler tpprotocolsdkgo.Handler = tpprotocolsdkgo.Handler{
-		// OnCreateDevice: OnCreateDevice,
-		// OnUpdateDevice: OnUpdateDevice,
-		// OnDeleteDevice: OnDeleteDevice,
-		OnDisconnectDevice: OnDisconnectDevice,
-		OnGetForm:          OnGetForm,
-	}
 	addr := viper.GetString("http_server.address")
 	logrus.Info("http服务启动：", addr)
-	err := handler.ListenAndServe(addr)
+	if adminToken() == "" {
+		logrus.Warn("未配置http_server.admin_token，运维接口（admin、access、trace、scan）已关闭")
+	}
+	err := http.ListenAndServe(addr, newServeMux())
 	if err != nil {
 		logrus.Info("ListenAndServe() failed, err: ", err)
 		return
 	}
 }
 
+// newServeMux 注册平台回调接口（路径与tp-protocol-sdk-go的Handler一致）和插件自身的运维接口
+func newServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	// 获取协议插件的json表单
+	mux.HandleFunc("/api/v1/form/config", allowMethod(http.MethodGet, OnGetForm))
+	// 断开设备连接
+	mux.HandleFunc("/api/v1/device/disconnect", allowMethod(http.MethodPost, OnDisconnectDevice))
+	// 平台通知事件（配置修改后重新同步已连接网关的配置）
+	mux.HandleFunc("/api/v1/notify/event", allowMethod(http.MethodPost, OnNotifyEvent))
+	// 以下运维接口需要管理令牌（http_server.admin_token），未配置时关闭
+	// 网关和子设备运行状态（只读）
+	mux.HandleFunc("/api/v1/admin/gateways", allowMethod(http.MethodGet, requireAdminToken(OnListGateways)))
+	mux.HandleFunc("/api/v1/admin/gateway", allowMethod(http.MethodGet, requireAdminToken(OnGetGateway)))
+	// IP访问控制
+	mux.HandleFunc("/api/v1/access/bans", allowMethod(http.MethodGet, requireAdminToken(OnListBans)))
+	mux.HandleFunc("/api/v1/access/unban", allowMethod(http.MethodPost, requireAdminToken(OnUnbanIP)))
+	// 按网关抓包
+	mux.HandleFunc("/api/v1/trace/start", allowMethod(http.MethodPost, requireAdminToken(OnStartTrace)))
+	mux.HandleFunc("/api/v1/trace/stop", allowMethod(http.MethodPost, requireAdminToken(OnStopTrace)))
+	mux.HandleFunc("/api/v1/trace/list", allowMethod(http.MethodGet, requireAdminToken(OnListTraces)))
+	mux.HandleFunc("/api/v1/trace/download", allowMethod(http.MethodGet, requireAdminToken(OnDownloadTrace)))
+	// 从站和地址范围扫描
+	mux.HandleFunc("/api/v1/scan/start", allowMethod(http.MethodPost, requireAdminToken(OnStartScan)))
+	mux.HandleFunc("/api/v1/scan/cancel", allowMethod(http.MethodPost, requireAdminToken(OnCancelScan)))
+	mux.HandleFunc("/api/v1/scan/result", allowMethod(http.MethodGet, requireAdminToken(OnGetScan)))
+	mux.HandleFunc("/api/v1/scan/list", allowMethod(http.MethodGet, requireAdminToken(OnListScans)))
+	// Prometheus监控指标
+	mux.Handle("/metrics", metrics.Handler())
+	// 存活和就绪检查
+	mux.HandleFunc("/healthz", allowMethod(http.MethodGet, OnHealthz))
+	mux.HandleFunc("/readyz", allowMethod(http.MethodGet, OnReadyz))
+	return mux
+}
+
+// allowMethod 限制请求方法
+func allowMethod(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		handler(w, r)
+	}
+}
+
 // OnDisconnectDevice 断开设备
 func OnDisconnectDevice(w http.ResponseWriter, r *http.Request) {
 	logrus.Info("OnDisconnectDevice")
@@ -103,6 +146,9 @@ func OnGetForm(w http.ResponseWriter, r *http.Request) {
 		if device_type == "3" {
 			// 子设备配置表单
 			RspSuccess(w, readFormConfigByPath("./form_config.json"))
+		} else if device_type == "2" {
+			// 网关配置表单
+			RspSuccess(w, readFormConfigByPath("./form_gateway_config.json"))
 		} else {
 			RspSuccess(w, nil)
 		}
@@ -161,131 +207,26 @@ func readFormConfigByPath(path string) interface{} {
 	}
 }
 
-// OnCreateDevice 创建设备
-// func OnCreateDevice(w http.ResponseWriter, r *http.Request) {
-// 	logrus.Info("OnCreateDevice")
-// 	r.ParseForm() //解析参数，默认是不会解析的
-// 	logrus.Info("【收到api请求】path", r.URL.Path)
-// 	logrus.Info("scheme", r.URL.Scheme)
-// 	// 读取客户端发送的数据
-// 	var reqDataMap = make(map[string]interface{})
-// 	if err := json.NewDecoder(r.Body).Decode(&reqDataMap); err != nil {
-// 		r.Body.Close()
-// 		w.WriteHeader(400)
-// 		return
-// 	}
-
-// 	gateWayID := reqDataMap["ParentId"].(string)
-// 	err := updateGatewayConfig(gateWayID)
-// 	if err != nil {
-// 		logrus.Info(err.Error())
-// 		r.Body.Close()
-// 		w.WriteHeader(400)
-// 		return
-// 	}
-// 	// 返回成功
-// 	var rspdata = make(map[string]interface{})
-// 	w.Header().Set("Content-Type", "application/json")
-// 	rspdata["code"] = 200
-// 	rspdata["message"] = "success"
-// 	data, err := json.Marshal(rspdata)
-// 	if err != nil {
-// 		logrus.Info(err.Error())
-// 	}
-// 	fmt.Fprint(w, string(data))
-// }
-
-// OnUpdateDevice 更新设备
-// func OnUpdateDevice(w http.ResponseWriter, r *http.Request) {
-// 	logrus.Info("OnUpdateDevice")
-// 	r.ParseForm() //解析参数，默认是不会解析的
-// 	logrus.Info("【收到api请求】path", r.URL.Path)
-// 	logrus.Info("scheme", r.URL.Scheme)
-// 	// 读取客户端发送的数据
-// 	var reqDataMap = make(map[string]interface{})
-// 	if err := json.NewDecoder(r.Body).Decode(&reqDataMap); err != nil {
-// 		r.Body.Close()
-// 		w.WriteHeader(400)
-// 		return
-// 	}
-
-// 	gateWayID := reqDataMap["ParentId"].(string)
-// 	err := updateGatewayConfig(gateWayID)
-// 	if err != nil {
-// 		logrus.Info(err.Error())
-// 		r.Body.Close()
-// 		w.WriteHeader(400)
-// 		return
-// 	}
-// 	// 返回成功
-// 	var rspdata = make(map[string]interface{})
-// 	w.Header().Set("Content-Type", "application/json")
-// 	rspdata["code"] = 200
-// 	rspdata["message"] = "success"
-// 	data, err := json.Marshal(rspdata)
-// 	if err != nil {
-// 		logrus.Info(err.Error())
-// 	}
-// 	fmt.Fprint(w, string(data))
-// }
-
-// OnDeleteDevice 删除设备
-// func OnDeleteDevice(w http.ResponseWriter, r *http.Request) {
-// 	logrus.Info("OnDeleteDevice")
-// 	r.ParseForm() //解析参数，默认是不会解析的
-// 	logrus.Info("【收到api请求】path", r.URL.Path)
-// 	logrus.Info("scheme", r.URL.Scheme)
-// 	// 读取客户端发送的数据
-// 	var reqDataMap = make(map[string]interface{})
-// 	if err := json.NewDecoder(r.Body).Decode(&reqDataMap); err != nil {
-// 		r.Body.Close()
-// 		w.WriteHeader(400)
-// 		return
-// 	}
-// 	deviceType := reqDataMap["DeviceType"].(string)
-// 	// 子设备
-// 	if deviceType == "3" {
-// 		gateWayID := reqDataMap["ParentId"].(string)
-// 		err := updateGatewayConfig(gateWayID)
-// 		if err != nil {
-// 			logrus.Info(err.Error())
-// 			r.Body.Close()
-// 			w.WriteHeader(400)
-// 			return
-// 		}
-// 	}
-// 	// 返回成功
-// 	var rspdata = make(map[string]interface{})
-// 	w.Header().Set("Content-Type", "application/json")
-// 	rspdata["code"] = 200
-// 	rspdata["message"] = "success"
-// 	data, err := json.Marshal(rspdata)
-// 	if err != nil {
-// 		logrus.Info(err.Error())
-// 	}
-// 	fmt.Fprint(w, string(data))
-// }
-
-// 更新配置
-// func updateGatewayConfig(gateWayID string) error {
-// 	// 获取网关配置
-// 	gatewayConfig, err := httpclient.GetDeviceConfig("", gateWayID)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	logrus.Info("网关配置：", gatewayConfig.Data)
-// 	// 获取连接
-// 	conn, ok := globaldata.DeviceConnectionMap.Load(gatewayConfig.Data.Voucher)
-// 	if ok {
-// 		c := *conn.(*net.Conn)
-// 		// 如果本身是关闭的也无所谓，它会在读和写的时候返回错误
-// 		service.CloseConnection(c, gatewayConfig.Data.Voucher)
-// 	} else {
-// 		return errors.New("Connection not found for token:" + gatewayConfig.Data.Voucher)
-// 	}
-// 	// 更换配置
-// 	globaldata.GateWayConfigMap.Store(gatewayConfig.Data.Voucher, &gatewayConfig.Data)
-// 	// 将设备连接存入全局变量
-// 	services.HandleConn(gatewayConfig.Data.Voucher, gatewayConfig.Data.ID) // 处理连接
-// 	return nil
-// }
+// updateGatewayConfig 重新获取网关配置，只重启变化的子设备采集任务，不断开网关连接
+// 按注册时的凭证获取，同时刷新本地配置缓存，避免之后平台不可用时退回旧配置；平台不可用时保持当前配置
+func updateGatewayConfig(gatewayID string) error {
+	voucher, ok := service.GatewayVoucher(gatewayID)
+	if !ok {
+		// 网关未连接，配置会在下次连接时获取
+		logrus.Info("网关未连接，配置将在下次连接时生效：", gatewayID)
+		return nil
+	}
+	// 获取网关配置
+	gatewayConfig, fromCache, err := httpclient.GetDeviceConfigWithCache(voucher)
+	if err != nil {
+		return err
+	}
+	if fromCache {
+		return fmt.Errorf("平台不可用，保持当前配置: %s", gatewayID)
+	}
+	if gatewayConfig.Data.ID != gatewayID {
+		return fmt.Errorf("平台返回的网关ID不一致: expected=%s, got=%s", gatewayID, gatewayConfig.Data.ID)
+	}
+	logrus.Info("网关配置：", gatewayConfig.Data)
+	return service.ReloadGatewayConfig(&gatewayConfig.Data)
+}
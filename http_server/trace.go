@@ -0,0 +1,117 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	frametrace "github.com/ThingsPanel/modbus-protocol-plugin/frame_trace"
+	globaldata "github.com/ThingsPanel/modbus-protocol-plugin/global_data"
+	"github.com/ThingsPanel/tp-protocol-sdk-go/api"
+	"github.com/sirupsen/logrus"
+)
+
+// OnStartTrace 开始抓取网关或子设备的收发帧
+// 请求体：{"gateway_id":"", "sub_device_id":"", "minutes":10, "capacity":10000}
+// 只指定sub_device_id时按子设备所属网关抓包，并只记录与该子设备通信期间的帧
+func OnStartTrace(w http.ResponseWriter, r *http.Request) {
+	logrus.Info("【收到api请求】path", r.URL.Path)
+	defer r.Body.Close()
+	var req struct {
+		GatewayID   string `json:"gateway_id"`
+		SubDeviceID string `json:"sub_device_id"`
+		Minutes     int    `json:"minutes"`
+		Capacity    int    `json:"capacity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RspError(w, errors.New("invalid request body"))
+		return
+	}
+	if req.SubDeviceID != "" {
+		v, ok := globaldata.SubDeviceIDAndGateWayIDMap.Load(req.SubDeviceID)
+		if !ok {
+			RspError(w, errors.New("sub device not found"))
+			return
+		}
+		if req.GatewayID != "" && req.GatewayID != v.(string) {
+			RspError(w, errors.New("sub device does not belong to gateway"))
+			return
+		}
+		req.GatewayID = v.(string)
+	}
+	if req.GatewayID == "" {
+		RspError(w, errors.New("gateway_id or sub_device_id is required"))
+		return
+	}
+
+	// 网关未连接时也可以开始抓包，网关连接后开始记录
+	protocol := ""
+	if v, ok := globaldata.GateWayConfigMap.Load(req.GatewayID); ok {
+		protocol = v.(*api.DeviceConfigResponseData).ProtocolType
+	}
+	status := frametrace.Start(req.GatewayID, req.SubDeviceID, protocol, time.Duration(req.Minutes)*time.Minute, req.Capacity)
+	logrus.Infof("开始抓包: gatewayID=%s, subDeviceID=%s, until=%s", status.GatewayID, status.SubDeviceID, status.Until.Format(time.RFC3339))
+	RspSuccess(w, status)
+}
+
+// OnStopTrace 停止抓包，请求体：{"gateway_id":""}，delete为true时同时删除记录
+func OnStopTrace(w http.ResponseWriter, r *http.Request) {
+	logrus.Info("【收到api请求】path", r.URL.Path)
+	defer r.Body.Close()
+	var req struct {
+		GatewayID string `json:"gateway_id"`
+		Delete    bool   `json:"delete"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RspError(w, errors.New("invalid request body"))
+		return
+	}
+	var ok bool
+	if req.Delete {
+		ok = frametrace.Delete(req.GatewayID)
+	} else {
+		ok = frametrace.Stop(req.GatewayID)
+	}
+	if !ok {
+		RspError(w, errors.New("trace not found"))
+		return
+	}
+	RspSuccess(w, nil)
+}
+
+// OnListTraces 查询所有抓包任务
+func OnListTraces(w http.ResponseWriter, r *http.Request) {
+	RspSuccess(w, frametrace.Statuses())
+}
+
+// OnDownloadTrace 下载抓包记录，参数：?gateway_id=网关ID&format=text|pcap
+func OnDownloadTrace(w http.ResponseWriter, r *http.Request) {
+	gatewayID := r.URL.Query().Get("gateway_id")
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "text"
+	}
+	if format != "text" && format != "pcap" {
+		RspError(w, errors.New("format must be text or pcap"))
+		return
+	}
+	records, status, err := frametrace.Records(gatewayID)
+	if err != nil {
+		RspError(w, errors.New("trace not found"))
+		return
+	}
+
+	if format == "pcap" {
+		w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+frametrace.FileName(gatewayID, "pcap")+`"`)
+		err = frametrace.WritePcap(w, records)
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+frametrace.FileName(gatewayID, "txt")+`"`)
+		err = frametrace.WriteText(w, records, status)
+	}
+	if err != nil {
+		logrus.Warnf("输出抓包记录失败: %v", err)
+	}
+}
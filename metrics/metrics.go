@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	globaldata "github.com/ThingsPanel/modbus-protocol-plugin/global_data"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "modbus_plugin"
+
+// 采集结果标签
+const (
+	PollOK         = "ok"         // 成功
+	PollTimeout    = "timeout"    // 超时无响应
+	PollException  = "exception"  // Modbus异常响应
+	PollCRC        = "crc"        // CRC校验失败（数据仍然上报）
+	PollDecode     = "decode"     // 响应解析或数据转换失败
+	PollConnection = "connection" // 连接错误
+)
+
+var (
+	registrations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registrations_total",
+		Help:      "网关注册次数，按结果和拒绝原因区分",
+	}, []string{"result", "reason"})
+
+	accessBlocks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "access_blocks_total",
+		Help:      "被IP访问控制拒绝的连接数",
+	}, []string{"reason"})
+
+	polls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "polls_total",
+		Help:      "采集请求次数（含重试），按网关、子设备和结果区分",
+	}, []string{"gateway_id", "sub_device_id", "result"})
+
+	responseLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "response_latency_seconds",
+		Help:      "从发送请求到收到完整响应的时间",
+		Buckets:   []float64{0.05, 0.1, 0.2, 0.3, 0.5, 0.75, 1, 1.5, 2, 3, 5},
+	}, []string{"protocol"})
+
+	mqttPublishFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_publish_failures_total",
+		Help:      "MQTT消息发布失败次数（含离线队列重发），按失败原因区分",
+	}, []string{"reason"})
+
+	controlCommands = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "control_commands_total",
+		Help:      "平台下发控制命令的执行结果",
+	}, []string{"protocol", "result"})
+
+	connectedGateways = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "connected_gateways",
+		Help:      "当前已连接的网关数",
+	}, func() float64 {
+		count := 0
+		globaldata.DeviceConnectionMap.Range(func(key, value interface{}) bool {
+			count++
+			return true
+		})
+		return float64(count)
+	})
+)
+
+func init() {
+	prometheus.MustRegister(registrations, accessBlocks, polls, responseLatency, mqttPublishFailures, controlCommands, connectedGateways)
+}
+
+// Handler /metrics接口
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RegistrationAccepted 网关注册成功
+func RegistrationAccepted() {
+	registrations.WithLabelValues("accepted", "").Inc()
+}
+
+// RegistrationRejected 网关注册被拒绝
+func RegistrationRejected(reason string) {
+	registrations.WithLabelValues("rejected", reason).Inc()
+}
+
+// AccessBlocked 连接被IP访问控制拒绝
+func AccessBlocked(reason string) {
+	accessBlocks.WithLabelValues(reason).Inc()
+}
+
+// RecordPoll 记录一次采集结果
+func RecordPoll(gatewayID string, subDeviceID string, result string) {
+	polls.WithLabelValues(gatewayID, subDeviceID, result).Inc()
+}
+
+// ObserveLatency 记录响应时间
+func ObserveLatency(protocol string, latency time.Duration) {
+	responseLatency.WithLabelValues(protocol).Observe(latency.Seconds())
+}
+
+// MQTTPublishFailed MQTT消息发布失败，reason为disconnected、timeout或error
+func MQTTPublishFailed(reason string) {
+	mqttPublishFailures.WithLabelValues(reason).Inc()
+}
+
+// ControlCommand 记录控制命令结果
+func ControlCommand(protocol string, result string) {
+	controlCommands.WithLabelValues(protocol, result).Inc()
+}
+
+// RemoveGateway 网关断开后删除其采集指标，避免已下线网关的序列一直保留
+func RemoveGateway(gatewayID string) {
+	polls.DeletePartialMatch(prometheus.Labels{"gateway_id": gatewayID})
+}
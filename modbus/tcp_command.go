@@ -59,6 +59,8 @@ func (t *TCPCommand) Serialize() ([]byte, error) {
 const MBAPHeaderLength = 7 // MBAP Header length for Modbus TCP (TransactionID:2 + ProtocolID:2 + Length:2 + UnitID:1)
 
 // 解析Modbus TCP返回的数据并提取数据部分
+// 返回的数据保留单元ID，与RTU帧的布局（地址、功能码、字节计数、数据）一致，供CommandRaw.Serialize解析；
+// CommandRaw.Serialize从resp[1]取功能码、从resp[3:]取数据，去掉单元ID会使功能码错位、数据少读第一个字节
 func (t *TCPCommand) ParseTCPResponse(resp []byte) ([]byte, error) {
 	if len(resp) < MBAPHeaderLength {
 		logrus.Error("response too short")
@@ -89,6 +91,6 @@ func (t *TCPCommand) ParseTCPResponse(resp []byte) ([]byte, error) {
 		return nil, fmt.Errorf("length mismatch: MBAP header reports %d bytes but received %d bytes", respLength, len(resp)-6)
 	}
 
-	// Extract and return the PDU (Protocol Data Unit) without the MBAP header
-	return resp[MBAPHeaderLength:], nil
+	// 去掉MBAP头中单元ID之前的部分，单元ID对应RTU帧中的从站地址
+	return resp[MBAPHeaderLength-1:], nil
 }
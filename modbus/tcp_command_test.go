@@ -0,0 +1,84 @@
+package modbus
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	tpconfig "github.com/ThingsPanel/modbus-protocol-plugin/tp_config"
+	"github.com/sirupsen/logrus"
+)
+
+// tcpResponse 按请求的TransactionID组装Modbus TCP响应
+func tcpResponse(cmd *TCPCommand, pdu ...byte) []byte {
+	length := len(pdu) + 1
+	resp := []byte{byte(cmd.RequestTransactionID >> 8), byte(cmd.RequestTransactionID), 0x00, 0x00, byte(length >> 8), byte(length), cmd.SlaveAddress}
+	return append(resp, pdu...)
+}
+
+// TestParseTCPResponseKeepsUnitID 解析结果保留单元ID，与RTU帧布局一致，CommandRaw.Serialize能正确取值
+func TestParseTCPResponseKeepsUnitID(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	cmd := NewTCPCommand(5, 0x03, 0, 2, BigEndian)
+	resp := tcpResponse(&cmd, 0x03, 0x04, 0x00, 0x2A, 0xFF, 0xFE)
+
+	data, err := cmd.ParseTCPResponse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x05, 0x03, 0x04, 0x00, 0x2A, 0xFF, 0xFE}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("解析结果为% X，期望% X", data, want)
+	}
+
+	commandRaw := &tpconfig.CommandRaw{
+		FunctionCode:         0x03,
+		Quantity:             2,
+		Endianess:            "BIG",
+		DataType:             "int16",
+		DataIdetifierListStr: "A1,A2",
+	}
+	values, err := commandRaw.Serialize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["A1"] != float64(42) || values["A2"] != float64(-2) {
+		t.Fatalf("解析值为%v，期望A1=42,A2=-2", values)
+	}
+}
+
+// TestParseTCPResponseException 异常响应的功能码位于resp[1]，CommandRaw.Serialize识别为异常
+func TestParseTCPResponseException(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	cmd := NewTCPCommand(1, 0x03, 0, 1, BigEndian)
+	data, err := cmd.ParseTCPResponse(tcpResponse(&cmd, 0x83, 0x02))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commandRaw := &tpconfig.CommandRaw{FunctionCode: 0x03, Quantity: 1, Endianess: "BIG", DataType: "int16", DataIdetifierListStr: "A1"}
+	if _, err := commandRaw.Serialize(data); err == nil {
+		t.Fatal("异常响应未被识别")
+	}
+}
+
+func TestParseTCPResponseInvalid(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	cmd := NewTCPCommand(1, 0x03, 0, 1, BigEndian)
+	valid := tcpResponse(&cmd, 0x03, 0x02, 0x00, 0x2A)
+
+	wrongID := append([]byte(nil), valid...)
+	wrongID[1]++
+	wrongProtocol := append([]byte(nil), valid...)
+	wrongProtocol[3] = 0x01
+	cases := map[string][]byte{
+		"长度不足":             valid[:6],
+		"TransactionID不匹配": wrongID,
+		"ProtocolID错误":     wrongProtocol,
+		"长度字段不匹配":          valid[:len(valid)-1],
+	}
+	for name, resp := range cases {
+		if _, err := cmd.ParseTCPResponse(resp); err == nil {
+			t.Errorf("%s: 期望返回错误", name)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package modbus
 
+import "encoding/binary"
+
 // ParseModbusExceptionResponse 解析Modbus异常响应
 // 返回 (isException, exceptionCode, functionCode)
 func ParseModbusExceptionResponse(data []byte, modbusType string) (bool, byte, byte) {
@@ -49,3 +51,11 @@ func crc16(data []byte) uint16 {
 	}
 	return crc
 }
+
+// ValidCRC 检查RTU帧末尾的CRC校验值
+func ValidCRC(frame []byte) bool {
+	if len(frame) < 3 {
+		return false
+	}
+	return binary.LittleEndian.Uint16(frame[len(frame)-2:]) == crc16(frame[:len(frame)-2])
+}
@@ -1,39 +1,314 @@
 package mqtt
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
+	frametrace "github.com/ThingsPanel/modbus-protocol-plugin/frame_trace"
 	globaldata "github.com/ThingsPanel/modbus-protocol-plugin/global_data"
+	"github.com/ThingsPanel/modbus-protocol-plugin/metrics"
 	"github.com/ThingsPanel/modbus-protocol-plugin/modbus"
 	tpconfig "github.com/ThingsPanel/modbus-protocol-plugin/tp_config"
 	"github.com/ThingsPanel/tp-protocol-sdk-go/api"
 	"github.com/sirupsen/logrus"
 
-	tpprotocolsdkgo "github.com/ThingsPanel/tp-protocol-sdk-go"
 	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gofrs/uuid"
 	"github.com/spf13/viper"
 )
 
-var MqttClient *tpprotocolsdkgo.MQTTClient
+var MqttClient *Client
 
+// 设备在线状态主题前缀，与平台约定一致
+const statusTopicPrefix = "devices/status/"
+
+// statusTopic 设备在线状态主题：devices/status/{deviceID}
+func statusTopic(deviceID string) string {
+	return statusTopicPrefix + deviceID
+}
+
+// warnStatusTopic 配置文件中的mqtt.status_topic不生效，与固定主题不同时提示
+// 旧版本的配置文件带有 status_topic: device/status，但在线状态一直发布到平台订阅的devices/status
+func warnStatusTopic() {
+	configured := strings.TrimSuffix(viper.GetString("mqtt.status_topic"), "/")
+	if configured != "" && configured+"/" != statusTopicPrefix {
+		logrus.Warnf("mqtt.status_topic=%s 已忽略，在线状态固定发布到平台订阅的主题 %s{设备ID}", configured, statusTopicPrefix)
+	}
+}
+
+// 发布消息等待确认的超时时间
+const publishTimeout = 10 * time.Second
+
+// 单次连接等待的超时时间
+const connectTimeout = 30 * time.Second
+
+// subscription 已登记的订阅，连接（含重连）成功后重新订阅
+type subscription struct {
+	qos      uint8
+	callback MQTT.MessageHandler
+}
+
+// Client MQTT客户端
+type Client struct {
+	client MQTT.Client
+
+	mutex          sync.Mutex
+	disconnectedAt time.Time               // 断开连接的时间，已连接时为零值
+	subscriptions  map[string]subscription // 主题 -> 订阅
+}
+
+// NewClient 创建MQTT客户端，连接（含自动重连）成功并重新订阅后回调onConnect
+// tlsConfig用于ssl://、mqtts://等TLS代理地址，为nil时使用默认配置
+func NewClient(broker string, username string, password string, tlsConfig *tls.Config, onConnect func()) *Client {
+	c := &Client{
+		disconnectedAt: time.Now(),
+		subscriptions:  make(map[string]subscription),
+	}
+	opts := MQTT.NewClientOptions()
+	opts.AddBroker(broker)
+	opts.SetClientID(uuid.Must(uuid.NewV4()).String())
+	opts.SetAutoReconnect(true) // 启用自动重新连接
+	opts.SetMaxReconnectInterval(connectRetryMaxInterval())
+	opts.SetConnectTimeout(connectTimeout)
+	opts.SetUsername(username)
+	opts.SetPassword(password)
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	opts.SetConnectionLostHandler(func(client MQTT.Client, err error) {
+		logrus.Warnf("mqtt连接丢失: %v", err)
+		c.setDisconnectedAt(time.Now())
+	})
+	opts.SetReconnectingHandler(func(client MQTT.Client, options *MQTT.ClientOptions) {
+		logrus.Info("mqtt正在重新连接...")
+	})
+	opts.SetOnConnectHandler(func(client MQTT.Client) {
+		c.setDisconnectedAt(time.Time{})
+		// 清除会话后代理不保留订阅，每次连接都重新订阅
+		c.resubscribe()
+		if onConnect != nil {
+			onConnect()
+		}
+	})
+	c.client = MQTT.NewClient(opts)
+	return c
+}
+
+func (c *Client) setDisconnectedAt(t time.Time) {
+	c.mutex.Lock()
+	c.disconnectedAt = t
+	c.mutex.Unlock()
+}
+
+// Connect 连接一次MQTT代理
+func (c *Client) Connect() error {
+	token := c.client.Connect()
+	if !token.WaitTimeout(connectTimeout) {
+		return fmt.Errorf("连接超时")
+	}
+	return token.Error()
+}
+
+// connectWithBackoff 按指数退避间隔重试连接，直到成功
+// 首次连接成功后由客户端自动重连，不再需要本方法
+func (c *Client) connectWithBackoff() {
+	wait := viper.GetDuration("mqtt.connect_retry.initial_interval")
+	if wait <= 0 {
+		wait = time.Second
+	}
+	maxWait := connectRetryMaxInterval()
+	for attempt := 1; ; attempt++ {
+		logrus.Warnf("mqtt连接失败，%v后第%d次重试", wait, attempt)
+		time.Sleep(wait)
+		err := c.Connect()
+		if err == nil {
+			logrus.Info("mqtt连接成功")
+			return
+		}
+		logrus.Warnf("mqtt连接失败: %v", err)
+		if wait *= 2; wait > maxWait {
+			wait = maxWait
+		}
+	}
+}
+
+// connectRetryMaxInterval 连接重试的最大间隔，同时作为自动重连的最大间隔
+func connectRetryMaxInterval() time.Duration {
+	if d := viper.GetDuration("mqtt.connect_retry.max_interval"); d > 0 {
+		return d
+	}
+	return time.Minute
+}
+
+// IsConnectionOpen 当前是否与MQTT代理保持连接（重连过程中返回false）
+func (c *Client) IsConnectionOpen() bool {
+	return c.client.IsConnectionOpen()
+}
+
+// DisconnectedSince 断开连接的时间（从未连接时为创建时间），已连接时返回零值
+func (c *Client) DisconnectedSince() time.Time {
+	if c.client.IsConnectionOpen() {
+		return time.Time{}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.disconnectedAt.IsZero() {
+		// 连接刚断开，断开回调尚未执行
+		return time.Now()
+	}
+	return c.disconnectedAt
+}
+
+// ConnectionState MQTT连接状态，客户端未创建时视为未连接
+func ConnectionState() (bool, time.Time) {
+	if MqttClient == nil {
+		return false, time.Time{}
+	}
+	since := MqttClient.DisconnectedSince()
+	return since.IsZero(), since
+}
+
+// Publish 发布消息到指定主题
+func (c *Client) Publish(topic string, payload string, qos uint8) error {
+	if !c.client.IsConnectionOpen() {
+		metrics.MQTTPublishFailed("disconnected")
+		return fmt.Errorf("mqtt未连接")
+	}
+	token := c.client.Publish(topic, qos, false, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		metrics.MQTTPublishFailed("timeout")
+		return fmt.Errorf("发布消息超时")
+	}
+	if err := token.Error(); err != nil {
+		metrics.MQTTPublishFailed("error")
+		return err
+	}
+	return nil
+}
+
+// Subscribe 登记订阅并立即订阅，未连接时在连接成功后订阅
+func (c *Client) Subscribe(topic string, callback MQTT.MessageHandler, qos uint8) error {
+	c.mutex.Lock()
+	c.subscriptions[topic] = subscription{qos: qos, callback: callback}
+	c.mutex.Unlock()
+	if !c.client.IsConnectionOpen() {
+		return nil
+	}
+	return c.subscribe(topic, qos, callback)
+}
+
+func (c *Client) subscribe(topic string, qos uint8, callback MQTT.MessageHandler) error {
+	token := c.client.Subscribe(topic, qos, callback)
+	if !token.WaitTimeout(publishTimeout) {
+		return fmt.Errorf("订阅超时")
+	}
+	return token.Error()
+}
+
+// resubscribe 连接成功后重新订阅所有已登记的主题
+func (c *Client) resubscribe() {
+	c.mutex.Lock()
+	subscriptions := make(map[string]subscription, len(c.subscriptions))
+	for topic, sub := range c.subscriptions {
+		subscriptions[topic] = sub
+	}
+	c.mutex.Unlock()
+
+	for topic, sub := range subscriptions {
+		if err := c.subscribe(topic, sub.qos, sub.callback); err != nil {
+			logrus.Errorf("重新订阅主题失败: topic=%s, err=%v", topic, err)
+			continue
+		}
+		logrus.Info("重新订阅主题成功:", topic)
+	}
+}
+
+// SendStatus 发送在线离线消息status 1-在线 0-离线
+func (c *Client) SendStatus(deviceID string, status string) error {
+	// 校验参数
+	if deviceID == "" {
+		return fmt.Errorf("deviceID不能为空")
+	}
+	if status != "1" && status != "0" {
+		return fmt.Errorf("status只能为1或0")
+	}
+	return c.Publish(statusTopic(deviceID), status, 1)
+}
+
+// DeviceStatus 设备当前的在线状态，status 1-在线 0-离线
+type DeviceStatus struct {
+	DeviceID string
+	Status   string
+}
+
+// statusProvider 返回所有设备的当前状态，由services注册
+// 连接回调在paho的协程中执行，可能与注册同时发生，读写需加锁
+var (
+	statusProvider      func() []DeviceStatus
+	statusProviderMutex sync.RWMutex
+)
+
+// SetStatusProvider 注册当前设备状态的来源，MQTT重连后据此重新上报，避免平台显示过时的状态
+func SetStatusProvider(provider func() []DeviceStatus) {
+	statusProviderMutex.Lock()
+	statusProvider = provider
+	statusProviderMutex.Unlock()
+}
+
+// onConnect 连接（含重连）成功后重新上报设备状态并重发离线队列
+func onConnect() {
+	statusProviderMutex.RLock()
+	provider := statusProvider
+	statusProviderMutex.RUnlock()
+	if provider != nil {
+		statuses := provider()
+		for _, status := range statuses {
+			// 离线队列中有未重发的消息时状态排在其后，保证顺序
+			if err := SendStatus(status.DeviceID, status.Status); err != nil {
+				logrus.Warnf("重新上报设备状态失败: deviceID=%s, err=%v", status.DeviceID, err)
+			}
+		}
+		if len(statuses) > 0 {
+			logrus.Infof("mqtt已连接，重新上报设备状态%d条", len(statuses))
+		}
+	}
+	triggerReplay()
+}
+
+// InitClient 创建MQTT客户端并连接，连接失败时在后台按退避间隔重试，不阻塞启动
+// 未连接期间的消息写入离线队列，订阅在连接成功后生效
 func InitClient() {
 	logrus.Info("创建mqtt客户端")
+	warnStatusTopic()
+	// 初始化离线队列，MQTT不可用时缓存消息
+	initOutbox()
 	// 创建新的MQTT客户端实例
 	addr := viper.GetString("mqtt.broker")
 	username := viper.GetString("mqtt.username")
 	password := viper.GetString("mqtt.password")
-	client := tpprotocolsdkgo.NewMQTTClient(addr, username, password)
+	tlsConfig, err := loadClientTLSConfig(addr)
+	if err != nil {
+		// 不能退回明文或不校验证书的连接，消息留在离线队列，健康检查报告MQTT断开
+		logrus.Errorf("mqtt TLS配置无效，不连接: %v", err)
+		MqttClient = NewClient(addr, username, password, nil, onConnect)
+		return
+	}
+	client := NewClient(addr, username, password, tlsConfig, onConnect)
+	MqttClient = client
 	// 尝试连接到MQTT代理
 	if err := client.Connect(); err != nil {
-		log.Fatalf("连接失败: %v", err)
+		logrus.Errorf("mqtt连接失败，后台继续重试: %v", err)
+		go client.connectWithBackoff()
+		return
 	}
 	logrus.Info("连接成功")
-	MqttClient = client
 }
 
 // 发布设备消息{"token":device_token,"values":{sub_device_addr1:{key:value...},sub_device_add2r:{key:value...}}}
@@ -41,8 +316,8 @@ func Publish(payload string) error {
 	// 主题
 	topic := viper.GetString("mqtt.topic_to_publish_sub")
 	qos := viper.GetUint("mqtt.qos")
-	// 发布消息
-	if err := MqttClient.Publish(topic, string(payload), uint8(qos)); err != nil {
+	// 发布消息，失败时写入离线队列
+	if err := publishOrEnqueue(topic, payload, uint8(qos)); err != nil {
 		log.Printf("发布消息失败: %v", err)
 		return err
 	}
@@ -50,6 +325,36 @@ func Publish(payload string) error {
 	return nil
 }
 
+// SendStatus 发送设备在线离线消息，失败时写入离线队列
+func SendStatus(deviceID string, status string) error {
+	if deviceID == "" {
+		return fmt.Errorf("deviceID不能为空")
+	}
+	return publishOrEnqueue(statusTopic(deviceID), status, 1)
+}
+
+// scanHandler 扫描请求的处理函数，由services注册
+var scanHandler func(payload []byte)
+
+// SetScanHandler 注册扫描请求（mqtt.scan_topic）的处理函数，需在Subscribe之前调用
+func SetScanHandler(handler func(payload []byte)) {
+	scanHandler = handler
+}
+
+// scanTopic 扫描请求主题，未配置时使用默认主题，配置为空时不订阅
+func scanTopic() string {
+	if viper.IsSet("mqtt.scan_topic") {
+		return viper.GetString("mqtt.scan_topic")
+	}
+	return "plugin/modbus/scan"
+}
+
+// isScanTopic 是否为扫描请求主题，该主题可能同时匹配控制主题的通配符
+func isScanTopic(topic string) bool {
+	t := scanTopic()
+	return t != "" && topic == t
+}
+
 // 订阅
 func Subscribe() {
 	// 主题
@@ -57,14 +362,33 @@ func Subscribe() {
 	qos := viper.GetUint("mqtt.qos")
 	// 订阅主题
 	if err := MqttClient.Subscribe(topic, messageHandler, uint8(qos)); err != nil {
-		log.Printf("订阅主题失败: %v", err)
+		log.Printf("订阅主题失败，重连后重新订阅: %v", err)
+	} else if MqttClient.IsConnectionOpen() {
+		logrus.Info("订阅主题成功:", topic)
+	} else {
+		logrus.Info("mqtt未连接，连接成功后订阅:", topic)
 	}
-	logrus.Info("订阅主题成功:", topic)
 
+	// 订阅扫描请求
+	scanTopic := scanTopic()
+	if scanTopic == "" || scanHandler == nil {
+		return
+	}
+	if err := MqttClient.Subscribe(scanTopic, func(client MQTT.Client, msg MQTT.Message) {
+		logrus.Info("收到扫描请求: ", string(msg.Payload()))
+		go scanHandler(msg.Payload())
+	}, uint8(qos)); err != nil {
+		log.Printf("订阅扫描主题失败，重连后重新订阅: %v", err)
+		return
+	}
+	logrus.Info("订阅主题成功:", scanTopic)
 }
 
 // 设备下发消息的回调函数：主题plugin/modbus/# payload：{sub_device_addr:{key:value...},sub_device_addr:{key:value...}}
 func messageHandler(client MQTT.Client, msg MQTT.Message) {
+	if isScanTopic(msg.Topic()) {
+		return
+	}
 	logrus.Info("Received message on topic: ", msg.Topic())
 	logrus.Info("Received message: ", string(msg.Payload()))
 	// 解析主题获取deviceID（plugin/modbus/devices/telemetry/control/# #为subDeviceID）
@@ -117,7 +441,7 @@ func messageHandler(client MQTT.Client, msg MQTT.Message) {
 							logrus.Info(err)
 							return
 						}
-						err = handleDeviceConnection(gateWayConfigMap.ID, sendData, gateWayConfigMap.Voucher, "MODBUS_RTU")
+						err = handleDeviceConnection(gateWayConfigMap.ID, subDevice.DeviceID, sendData, gateWayConfigMap.Voucher, "MODBUS_RTU")
 						if err != nil {
 							logrus.Info(err)
 							return
@@ -150,7 +474,7 @@ func messageHandler(client MQTT.Client, msg MQTT.Message) {
 							logrus.Info(err)
 							return
 						}
-						err = handleDeviceConnection(gateWayConfigMap.ID, sendData, gateWayConfigMap.Voucher, "MODBUS_TCP")
+						err = handleDeviceConnection(gateWayConfigMap.ID, subDevice.DeviceID, sendData, gateWayConfigMap.Voucher, "MODBUS_TCP")
 						if err != nil {
 							logrus.Info(err)
 							return
@@ -170,7 +494,16 @@ func messageHandler(client MQTT.Client, msg MQTT.Message) {
 }
 
 // 处理设备连接
-func handleDeviceConnection(deviceID string, sendData []byte, voucher string, protocolType string) error {
+func handleDeviceConnection(deviceID string, subDeviceID string, sendData []byte, voucher string, protocolType string) (err error) {
+	// 控制结果计入监控指标
+	result := ""
+	defer func() {
+		if result == "" {
+			result = controlResult(err)
+		}
+		metrics.ControlCommand(protocolType, result)
+	}()
+
 	// 获取连接
 	c, exists := globaldata.DeviceConnectionMap.Load(deviceID)
 	if !exists {
@@ -179,21 +512,23 @@ func handleDeviceConnection(deviceID string, sendData []byte, voucher string, pr
 	conn := *c.(*net.Conn)
 
 	// 设置写超时时间
-	err := conn.SetWriteDeadline(time.Now().Add(15 * time.Second))
+	err = conn.SetWriteDeadline(time.Now().Add(15 * time.Second))
 	if err != nil {
 		logrus.Info("SetWriteDeadline() failed, err: ", err)
 		return err
 	}
 	regPkg, isTrue := globaldata.GetRegPkgByToken(voucher)
 	if isTrue {
-		globaldata.DeviceRWLock[regPkg].Lock()
+		lock := globaldata.GetDeviceLock(regPkg)
+		lock.Lock()
 		logrus.Info("获取到锁：", regPkg)
-		defer globaldata.DeviceRWLock[regPkg].Unlock()
+		defer lock.Unlock()
 	}
+	frametrace.SetSubDevice(deviceID, subDeviceID)
 	logrus.Info("voucher:", voucher, "控制设备请求：", sendData)
 	_, err = conn.Write(sendData)
 	if err != nil {
-		return fmt.Errorf("写入失败: %v", err)
+		return fmt.Errorf("写入失败: %w", err)
 	}
 
 	// 读取数据
@@ -205,12 +540,17 @@ func handleDeviceConnection(deviceID string, sendData []byte, voucher string, pr
 	}
 	var buf []byte
 	if protocolType == "MODBUS_RTU" {
-		buf, err = ReadModbusRTUResponse(conn, regPkg)
+		buf, err = ReadModbusRTUResponse(conn, globaldata.GetFramePrefix(deviceID))
 	} else if protocolType == "MODBUS_TCP" {
-		buf, err = ReadModbusTCPResponse(conn, regPkg)
+		buf, err = ReadModbusTCPResponse(conn, globaldata.GetFramePrefix(deviceID))
 	}
 	if err != nil {
-		return fmt.Errorf("读取失败: %v", err)
+		return fmt.Errorf("读取失败: %w", err)
+	}
+
+	if len(buf) == 0 {
+		// 设备未响应，仍按原逻辑返回成功，只在指标中区分
+		result = "no_response"
 	}
 
 	// 检查是否是Modbus异常响应
@@ -223,6 +563,7 @@ func handleDeviceConnection(deviceID string, sendData []byte, voucher string, pr
 		desc := globaldata.GetModbusErrorDesc(exceptionCode)
 		errMsg := fmt.Sprintf("Modbus异常响应: function_code=0x%02X, exception_code=0x%02X, %s", functionCode, exceptionCode, desc)
 		logrus.Warn("voucher:", voucher, "控制设备失败:", errMsg)
+		result = "exception"
 		return fmt.Errorf("控制失败: %s", errMsg)
 	}
 
@@ -230,6 +571,19 @@ func handleDeviceConnection(deviceID string, sendData []byte, voucher string, pr
 	return nil
 }
 
+// controlResult 控制命令结果标签：ok、timeout或error
+// Modbus异常响应（exception）和设备未响应（no_response）由调用方标记
+func controlResult(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	return "error"
+}
+
 // 根据key、value组装发送
 func PublishRsponse(key string, value interface{}, subDeviceID string) error {
 	dataMap := make(map[string]interface{})
@@ -0,0 +1,18 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// TestStatusTopicFixed 在线状态固定发布到平台订阅的devices/status，旧配置的status_topic不改变主题
+func TestStatusTopicFixed(t *testing.T) {
+	defer viper.Set("mqtt.status_topic", nil)
+	for _, configured := range []string{"", "devices/status", "device/status"} {
+		viper.Set("mqtt.status_topic", configured)
+		if got := statusTopic("d1"); got != "devices/status/d1" {
+			t.Fatalf("status_topic=%q时主题为%s，期望devices/status/d1", configured, got)
+		}
+	}
+}
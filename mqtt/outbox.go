@@ -0,0 +1,142 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	offlinequeue "github.com/ThingsPanel/modbus-protocol-plugin/offline_queue"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// 离线队列，MQTT不可用时缓存待发布的消息，为nil表示未启用
+var outbox *offlinequeue.Queue
+
+// 触发离线队列重发的信号
+var replayChan = make(chan struct{}, 1)
+
+// initOutbox 初始化离线队列并启动重发协程
+func initOutbox() {
+	if !viper.GetBool("offline_queue.enabled") {
+		return
+	}
+	dir := viper.GetString("offline_queue.dir")
+	if dir == "" {
+		dir = "./data/offline_queue"
+	}
+	maxBytes := viper.GetInt64("offline_queue.max_bytes")
+	if maxBytes <= 0 {
+		maxBytes = 64 << 20 // 默认64MB
+	}
+	segmentBytes := viper.GetInt64("offline_queue.segment_bytes")
+	if segmentBytes <= 0 {
+		segmentBytes = 4 << 20 // 默认4MB
+	}
+
+	queue, err := offlinequeue.Open(dir, maxBytes, segmentBytes)
+	if err != nil {
+		logrus.Errorf("打开离线队列失败，MQTT不可用时消息将丢失: %v", err)
+		return
+	}
+	outbox = queue
+	logrus.Infof("离线队列初始化: dir=%s, max_bytes=%d, 待重发=%d", dir, maxBytes, queue.Len())
+	go replayLoop()
+}
+
+// publishOrEnqueue 发布消息，MQTT不可用或离线队列中还有未重发的消息时写入离线队列，保证顺序
+func publishOrEnqueue(topic string, payload string, qos uint8) error {
+	if outbox == nil {
+		return MqttClient.Publish(topic, payload, qos)
+	}
+
+	if outbox.Len() == 0 && MqttClient.IsConnectionOpen() {
+		err := MqttClient.Publish(topic, payload, qos)
+		if err == nil {
+			return nil
+		}
+		logrus.Warnf("发布消息失败，写入离线队列: %v", err)
+	}
+
+	msg := &offlinequeue.Message{
+		Topic:     topic,
+		Payload:   payload,
+		Qos:       qos,
+		Timestamp: time.Now(),
+	}
+	if err := outbox.Append(msg); err != nil {
+		return err
+	}
+	logrus.Debugf("消息已写入离线队列: topic=%s, 待重发=%d", topic, outbox.Len())
+	triggerReplay()
+	return nil
+}
+
+// triggerReplay 通知重发协程，MQTT重连成功时调用
+func triggerReplay() {
+	select {
+	case replayChan <- struct{}{}:
+	default:
+	}
+}
+
+// replayLoop 按写入顺序重发离线队列中的消息
+func replayLoop() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-replayChan:
+		case <-ticker.C:
+		}
+		replayOutbox()
+	}
+}
+
+// replayOutbox 重发直到队列为空或发布失败
+func replayOutbox() {
+	replayed := 0
+	for MqttClient != nil && MqttClient.IsConnectionOpen() {
+		msg, err := outbox.Peek()
+		if err != nil {
+			logrus.Errorf("读取离线队列失败: %v", err)
+			return
+		}
+		if msg == nil {
+			break
+		}
+		if err := MqttClient.Publish(msg.Topic, replayPayload(msg), msg.Qos); err != nil {
+			logrus.Warnf("重发离线消息失败，等待下次重试: %v", err)
+			return
+		}
+		if err := outbox.Ack(); err != nil {
+			logrus.Errorf("更新离线队列进度失败: %v", err)
+		}
+		replayed++
+	}
+	if replayed > 0 {
+		logrus.Infof("离线队列重发完成: 本次重发 %d 条，剩余 %d 条", replayed, outbox.Len())
+	}
+}
+
+// replayPayload 重发的载荷，带上消息产生的时间，避免平台按重发时间记录
+// JSON对象没有ts字段时补充写入队列的时间（毫秒），已有ts（遥测、异常上报的采集时间）不覆盖；
+// 在线状态载荷为平台规定的"1"/"0"，无法携带时间，原样重发
+func replayPayload(msg *offlinequeue.Message) string {
+	if msg.Timestamp.IsZero() {
+		return msg.Payload
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(msg.Payload), &fields); err != nil || fields == nil {
+		return msg.Payload
+	}
+	if _, ok := fields["ts"]; ok {
+		return msg.Payload
+	}
+	fields["ts"] = json.RawMessage(strconv.FormatInt(msg.Timestamp.UnixMilli(), 10))
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return msg.Payload
+	}
+	return string(payload)
+}
@@ -0,0 +1,30 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	offlinequeue "github.com/ThingsPanel/modbus-protocol-plugin/offline_queue"
+)
+
+func TestReplayPayload(t *testing.T) {
+	ts := time.UnixMilli(1700000000123)
+	cases := []struct {
+		name    string
+		payload string
+		want    string
+	}{
+		{"补充ts", `{"device_id":"d1","values":"eyJBMSI6MX0="}`, `{"device_id":"d1","ts":1700000000123,"values":"eyJBMSI6MX0="}`},
+		{"保留采集时间", `{"device_id":"d1","ts":1600000000000}`, `{"device_id":"d1","ts":1600000000000}`},
+		{"在线状态", "1", "1"},
+		{"非JSON", "hello", "hello"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := replayPayload(&offlinequeue.Message{Payload: c.payload, Timestamp: ts})
+			if got != c.want {
+				t.Fatalf("重发载荷为%s，期望%s", got, c.want)
+			}
+		})
+	}
+}
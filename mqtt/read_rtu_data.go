@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 
+	globaldata "github.com/ThingsPanel/modbus-protocol-plugin/global_data"
 	"github.com/sirupsen/logrus"
 )
 
@@ -16,33 +17,36 @@ func isTimeout(err error) bool {
 	return false
 }
 
-func ReadModbusRTUResponse(conn net.Conn, regPkg string) ([]byte, error) {
+// ReadModbusRTUResponse 读取Modbus RTU响应
+// framePrefix: 帧前缀模式下每包前的前缀，未开启时为nil
+func ReadModbusRTUResponse(conn net.Conn, framePrefix []byte) ([]byte, error) {
 	var buffer bytes.Buffer
 	readBuffer := make([]byte, 256)
 
 	// 最多读取两次
 	for i := 0; i < 2; i++ {
 		n, err := conn.Read(readBuffer)
-		logrus.Info("---------读取数据详情(第", i+1, "次)---------")
-		logrus.Info("读取字节数: ", n)
+		logrus.Debug("---------读取数据详情(第", i+1, "次)---------")
+		logrus.Debug("读取字节数: ", n)
 		if n > 0 {
-			logrus.Info("数据内容(hex): ", hex.EncodeToString(readBuffer[:n]))
-			logrus.Info("数据内容(bytes): ", readBuffer[:n])
+			logrus.Debug("数据内容(hex): ", hex.EncodeToString(readBuffer[:n]))
+			logrus.Debug("数据内容(bytes): ", readBuffer[:n])
 		}
 		if err != nil {
-			logrus.Info("读取错误: ", err)
-			logrus.Info("-----------------------------")
+			logrus.Debug("读取错误: ", err)
+			logrus.Debug("-----------------------------")
 			if !isTimeout(err) {
 				return nil, fmt.Errorf("连接异常: %v", err)
 			}
 			break
 		}
-		logrus.Info("-----------------------------")
+		logrus.Debug("-----------------------------")
 
-		buffer.Write(readBuffer[:n])
+		// 帧前缀模式：剔除每包开头的前缀，DTU可能把一帧拆成多包发送
+		buffer.Write(globaldata.TrimFramePrefix(readBuffer[:n], framePrefix))
 
 		// 尝试解析modbus响应
-		if modbusData := findModbusResponse(buffer.Bytes()); modbusData != nil {
+		if modbusData := findModbusResponse(buffer.Bytes(), len(framePrefix) == 0); modbusData != nil {
 			return modbusData, nil
 		}
 
@@ -55,13 +59,15 @@ func ReadModbusRTUResponse(conn net.Conn, regPkg string) ([]byte, error) {
 	return nil, nil
 }
 
-func findModbusResponse(data []byte) []byte {
+// findModbusResponse 在数据中查找有效的Modbus响应
+// skipDigits: 是否跳过0x30-0x39（数字字符干扰），帧前缀模式下不跳过
+func findModbusResponse(data []byte, skipDigits bool) []byte {
 	if len(data) < 5 {
 		return nil
 	}
 
 	for i := 0; i < len(data)-4; i++ {
-		if data[i] >= 0x30 && data[i] <= 0x39 {
+		if skipDigits && data[i] >= 0x30 && data[i] <= 0x39 {
 			continue
 		}
 
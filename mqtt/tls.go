@@ -0,0 +1,78 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// tlsSchemes 使用TLS连接的代理地址协议
+var tlsSchemes = []string{"ssl", "tls", "mqtts", "mqtt+ssl", "tcps"}
+
+// isTLSBroker 代理地址是否使用TLS，如 ssl://host:8883 或 mqtts://host:8883
+func isTLSBroker(broker string) bool {
+	u, err := url.Parse(broker)
+	if err != nil || !strings.Contains(broker, "://") {
+		return false
+	}
+	for _, scheme := range tlsSchemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadClientTLSConfig 读取mqtt.tls配置
+// 代理地址不是TLS协议时返回nil；没有配置ca_file时使用系统根证书校验代理证书
+func loadClientTLSConfig(broker string) (*tls.Config, error) {
+	caFile := viper.GetString("mqtt.tls.ca_file")
+	certFile := viper.GetString("mqtt.tls.cert_file")
+	keyFile := viper.GetString("mqtt.tls.key_file")
+	if !isTLSBroker(broker) {
+		if caFile != "" || certFile != "" {
+			logrus.Warnf("mqtt代理地址%s不是ssl://或mqtts://，忽略mqtt.tls配置", broker)
+		}
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         viper.GetString("mqtt.tls.server_name"),
+		InsecureSkipVerify: viper.GetBool("mqtt.tls.insecure_skip_verify"),
+	}
+	if config.InsecureSkipVerify {
+		logrus.Warn("mqtt.tls.insecure_skip_verify已开启，不校验代理证书，只能用于测试")
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取CA证书失败: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA证书无效: %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("客户端证书cert_file和私钥key_file必须同时配置")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载客户端证书失败: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,206 @@
+package mqtt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/spf13/viper"
+)
+
+// testCA 测试用CA，签发代理和客户端证书
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue 签发证书，返回证书和私钥的PEM
+func (ca *testCA) issue(t *testing.T, cn string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// startTLSBroker 启动要求客户端证书的TLS代理，返回监听地址
+func startTLSBroker(t *testing.T, ca *testCA) string {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, "broker", x509.ExtKeyUsageServerAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.pem)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	broker := mochi.New(&mochi.Options{InlineClient: true})
+	if err := broker.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	err = broker.AddListener(listeners.NewTCP(listeners.Config{
+		ID:      "tls",
+		Address: addr,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			MinVersion:   tls.VersionTLS12,
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { broker.Close() })
+	return addr
+}
+
+// setTLSConfig 设置mqtt.tls配置，测试结束后清除
+func setTLSConfig(t *testing.T, values map[string]interface{}) {
+	t.Helper()
+	for key, value := range values {
+		viper.Set("mqtt.tls."+key, value)
+	}
+	t.Cleanup(func() {
+		for key := range values {
+			viper.Set("mqtt.tls."+key, nil)
+		}
+	})
+}
+
+func TestTLSConnect(t *testing.T) {
+	ca := newTestCA(t)
+	addr := startTLSBroker(t, ca)
+	dir := t.TempDir()
+	certPEM, keyPEM := ca.issue(t, "plugin", x509.ExtKeyUsageClientAuth)
+	caFile := writeFile(t, dir, "ca.crt", ca.pem)
+	certFile := writeFile(t, dir, "client.crt", certPEM)
+	keyFile := writeFile(t, dir, "client.key", keyPEM)
+
+	cases := []struct {
+		name    string
+		scheme  string
+		values  map[string]interface{}
+		connect bool
+	}{
+		{"客户端证书", "ssl", map[string]interface{}{"ca_file": caFile, "cert_file": certFile, "key_file": keyFile}, true},
+		{"mqtts地址", "mqtts", map[string]interface{}{"ca_file": caFile, "cert_file": certFile, "key_file": keyFile}, true},
+		{"跳过校验", "ssl", map[string]interface{}{"insecure_skip_verify": true, "cert_file": certFile, "key_file": keyFile}, true},
+		{"缺少客户端证书", "ssl", map[string]interface{}{"ca_file": caFile}, false},
+		{"不信任代理证书", "ssl", map[string]interface{}{"cert_file": certFile, "key_file": keyFile}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setTLSConfig(t, c.values)
+			broker := c.scheme + "://" + addr
+			config, err := loadClientTLSConfig(broker)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := NewClient(broker, "", "", config, nil)
+			err = client.Connect()
+			if c.connect {
+				if err != nil {
+					t.Fatalf("连接失败: %v", err)
+				}
+				client.client.Disconnect(0)
+			} else if err == nil {
+				client.client.Disconnect(0)
+				t.Fatal("期望连接失败")
+			}
+		})
+	}
+}
+
+func TestLoadClientTLSConfig(t *testing.T) {
+	// 明文地址忽略TLS配置
+	setTLSConfig(t, map[string]interface{}{"ca_file": "missing.crt"})
+	for _, broker := range []string{"127.0.0.1:1883", "tcp://127.0.0.1:1883"} {
+		if config, err := loadClientTLSConfig(broker); config != nil || err != nil {
+			t.Fatalf("%s: 期望不使用TLS，得到%v, %v", broker, config, err)
+		}
+	}
+	// TLS地址的配置错误直接报告，不退回明文
+	if _, err := loadClientTLSConfig("ssl://127.0.0.1:8883"); err == nil {
+		t.Fatal("CA证书不存在时期望报错")
+	}
+	setTLSConfig(t, map[string]interface{}{"ca_file": "", "cert_file": "client.crt"})
+	if _, err := loadClientTLSConfig("mqtts://127.0.0.1:8883"); err == nil {
+		t.Fatal("只配置证书没有私钥时期望报错")
+	}
+}
@@ -0,0 +1,429 @@
+package offlinequeue
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	segmentSuffix  = ".seg"
+	cursorFileName = "cursor"
+	recordHeadSize = 4 // 记录头：4字节大端长度
+)
+
+// ErrQueueFull 队列已满（单个段已超过容量上限）
+var ErrQueueFull = errors.New("offline queue is full")
+
+// Message 缓存的一条MQTT消息
+type Message struct {
+	Topic     string    `json:"topic"`
+	Payload   string    `json:"payload"`
+	Qos       uint8     `json:"qos"`
+	Timestamp time.Time `json:"ts"` // 消息产生的时间
+}
+
+// segment 一个段文件
+type segment struct {
+	id      uint64
+	size    int64 // 文件字节数
+	records int   // 记录条数
+}
+
+// Queue 基于追加写段文件的磁盘队列
+// 消息按写入顺序追加到段文件，消费进度记录在cursor文件中，已消费完的段文件会被删除
+// 总容量超过maxBytes时丢弃最旧的段
+type Queue struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+
+	mutex    sync.Mutex
+	segments []*segment // 升序，最后一个为写入段
+	writer   *os.File
+	reader   *os.File // 队首段的读句柄
+	readOff  int64    // 队首段的读取偏移
+	readIdx  int      // 队首段已消费的记录数
+	peekLen  int64    // 上次Peek的记录长度（含记录头），Ack时使用
+	pending  int      // 未消费的记录数
+}
+
+// Open 打开（或创建）目录下的队列，并恢复上次的消费进度
+func Open(dir string, maxBytes, segmentBytes int64) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if segmentBytes <= 0 {
+		segmentBytes = 4 << 20
+	}
+	if maxBytes < segmentBytes {
+		maxBytes = segmentBytes
+	}
+
+	q := &Queue{
+		dir:          dir,
+		maxBytes:     maxBytes,
+		segmentBytes: segmentBytes,
+	}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// load 扫描段文件并恢复消费进度
+func (q *Queue) load() error {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+	var ids []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	cursorSeg, cursorOff := q.readCursor()
+	for i, id := range ids {
+		// 游标之前的段已经消费完，直接删除
+		if id < cursorSeg {
+			os.Remove(q.segmentPath(id))
+			continue
+		}
+		seg, err := q.scanSegment(id, i == len(ids)-1)
+		if err != nil {
+			return err
+		}
+		q.segments = append(q.segments, seg)
+		q.pending += seg.records
+	}
+
+	if len(q.segments) > 0 && q.segments[0].id == cursorSeg {
+		// 统计队首段中已消费的记录
+		off, idx, err := q.countRecords(cursorSeg, cursorOff)
+		if err != nil {
+			return err
+		}
+		q.readOff = off
+		q.readIdx = idx
+		q.pending -= idx
+	}
+
+	if q.pending > 0 {
+		logrus.Infof("离线队列恢复: %d 条消息待重发", q.pending)
+	}
+	return nil
+}
+
+// scanSegment 统计段文件中的记录，最后一个段若尾部记录不完整则截断
+func (q *Queue) scanSegment(id uint64, last bool) (*segment, error) {
+	f, err := os.Open(q.segmentPath(id))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	seg := &segment{id: id}
+	head := make([]byte, recordHeadSize)
+	for {
+		if _, err := io.ReadFull(f, head); err != nil {
+			break
+		}
+		n := int64(binary.BigEndian.Uint32(head))
+		if _, err := f.Seek(n, io.SeekCurrent); err != nil {
+			break
+		}
+		info, err := f.Stat()
+		if err != nil || seg.size+recordHeadSize+n > info.Size() {
+			break
+		}
+		seg.size += recordHeadSize + n
+		seg.records++
+	}
+
+	if info, err := f.Stat(); err == nil && info.Size() > seg.size {
+		if last {
+			logrus.Warnf("离线队列段文件尾部不完整，截断: %s", q.segmentPath(id))
+			if err := os.Truncate(q.segmentPath(id), seg.size); err != nil {
+				return nil, err
+			}
+		} else {
+			seg.size = info.Size()
+		}
+	}
+	return seg, nil
+}
+
+// countRecords 统计段文件中偏移limit之前的完整记录
+func (q *Queue) countRecords(id uint64, limit int64) (int64, int, error) {
+	f, err := os.Open(q.segmentPath(id))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var off int64
+	idx := 0
+	head := make([]byte, recordHeadSize)
+	for off < limit {
+		if _, err := f.ReadAt(head, off); err != nil {
+			break
+		}
+		off += recordHeadSize + int64(binary.BigEndian.Uint32(head))
+		idx++
+	}
+	return off, idx, nil
+}
+
+// Append 追加一条消息
+func (q *Queue) Append(msg *Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	recordLen := int64(recordHeadSize + len(body))
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	// 当前写入段写满则滚动
+	if q.writer == nil || (q.tail().size > 0 && q.tail().size+recordLen > q.segmentBytes) {
+		if err := q.roll(); err != nil {
+			return err
+		}
+	}
+
+	// 超过总容量时丢弃最旧的段
+	for q.totalSize()+recordLen > q.maxBytes {
+		if len(q.segments) <= 1 {
+			return ErrQueueFull
+		}
+		q.dropHead()
+	}
+
+	record := make([]byte, recordLen)
+	binary.BigEndian.PutUint32(record, uint32(len(body)))
+	copy(record[recordHeadSize:], body)
+	if _, err := q.writer.Write(record); err != nil {
+		return err
+	}
+	tail := q.tail()
+	tail.size += recordLen
+	tail.records++
+	q.pending++
+	return nil
+}
+
+// Peek 返回队首消息但不移除，队列为空时返回nil
+func (q *Queue) Peek() (*Message, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.pending == 0 {
+		return nil, nil
+	}
+	// 跳过已经消费完的段
+	for q.readIdx >= q.segments[0].records {
+		q.removeHead()
+	}
+
+	if q.reader == nil {
+		f, err := os.Open(q.segmentPath(q.segments[0].id))
+		if err != nil {
+			return nil, err
+		}
+		q.reader = f
+	}
+
+	head := make([]byte, recordHeadSize)
+	if _, err := q.reader.ReadAt(head, q.readOff); err != nil {
+		return nil, fmt.Errorf("读取离线队列记录头失败: %w", err)
+	}
+	body := make([]byte, binary.BigEndian.Uint32(head))
+	if _, err := q.reader.ReadAt(body, q.readOff+recordHeadSize); err != nil {
+		return nil, fmt.Errorf("读取离线队列记录失败: %w", err)
+	}
+
+	var msg Message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, fmt.Errorf("解析离线队列记录失败: %w", err)
+	}
+	q.peekLen = recordHeadSize + int64(len(body))
+	return &msg, nil
+}
+
+// Ack 确认队首消息已处理，移除并持久化消费进度
+func (q *Queue) Ack() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.peekLen == 0 || q.pending == 0 {
+		return nil
+	}
+	q.readOff += q.peekLen
+	q.readIdx++
+	q.peekLen = 0
+	q.pending--
+
+	if q.readIdx >= q.segments[0].records {
+		q.removeHead()
+		return nil
+	}
+	return q.writeCursor()
+}
+
+// Len 未消费的消息条数
+func (q *Queue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.pending
+}
+
+// Close 关闭队列
+func (q *Queue) Close() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.reader != nil {
+		q.reader.Close()
+		q.reader = nil
+	}
+	if q.writer != nil {
+		q.writer.Sync()
+		err := q.writer.Close()
+		q.writer = nil
+		return err
+	}
+	return nil
+}
+
+// roll 新建写入段
+func (q *Queue) roll() error {
+	if q.writer != nil {
+		q.writer.Sync()
+		q.writer.Close()
+		q.writer = nil
+	}
+
+	var id uint64 = 1
+	if tail := q.tail(); tail != nil {
+		id = tail.id
+		// 已有内容的段不再追加，新建一个段
+		if tail.size > 0 {
+			id++
+		}
+	}
+	f, err := os.OpenFile(q.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	q.writer = f
+	if tail := q.tail(); tail == nil || tail.id != id {
+		q.segments = append(q.segments, &segment{id: id})
+	}
+	return nil
+}
+
+// dropHead 容量不足时丢弃队首段中未消费的消息
+func (q *Queue) dropHead() {
+	head := q.segments[0]
+	dropped := head.records - q.readIdx
+	q.pending -= dropped
+	logrus.Warnf("离线队列容量不足，丢弃最旧的 %d 条消息", dropped)
+	q.readIdx = head.records
+	q.removeHead()
+}
+
+// removeHead 删除已消费完的队首段
+func (q *Queue) removeHead() {
+	head := q.segments[0]
+	if q.reader != nil {
+		q.reader.Close()
+		q.reader = nil
+	}
+	q.readOff = 0
+	q.readIdx = 0
+	q.peekLen = 0
+
+	// 队首同时也是写入段时，关闭写句柄后删除，下次写入重新建段
+	if len(q.segments) == 1 && q.writer != nil {
+		q.writer.Close()
+		q.writer = nil
+	}
+	if err := os.Remove(q.segmentPath(head.id)); err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("删除离线队列段文件失败: %v", err)
+	}
+	q.segments = q.segments[1:]
+
+	if len(q.segments) > 0 {
+		q.writeCursor()
+	} else {
+		os.Remove(filepath.Join(q.dir, cursorFileName))
+	}
+}
+
+func (q *Queue) tail() *segment {
+	if len(q.segments) == 0 {
+		return nil
+	}
+	return q.segments[len(q.segments)-1]
+}
+
+func (q *Queue) totalSize() int64 {
+	var total int64
+	for _, seg := range q.segments {
+		total += seg.size
+	}
+	return total
+}
+
+func (q *Queue) segmentPath(id uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", id, segmentSuffix))
+}
+
+// readCursor 读取消费进度：段序号和段内偏移
+func (q *Queue) readCursor() (uint64, int64) {
+	data, err := os.ReadFile(filepath.Join(q.dir, cursorFileName))
+	if err != nil {
+		return 0, 0
+	}
+	var seg uint64
+	var off int64
+	if _, err := fmt.Sscanf(string(data), "%d %d", &seg, &off); err != nil {
+		return 0, 0
+	}
+	return seg, off
+}
+
+// writeCursor 持久化消费进度（先写临时文件再重命名，避免写一半）
+func (q *Queue) writeCursor() error {
+	if len(q.segments) == 0 {
+		return nil
+	}
+	path := filepath.Join(q.dir, cursorFileName)
+	tmp := path + ".tmp"
+	data := fmt.Sprintf("%d %d", q.segments[0].id, q.readOff)
+	if err := os.WriteFile(tmp, []byte(data), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
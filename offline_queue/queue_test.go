@@ -0,0 +1,159 @@
+package offlinequeue
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func openQueue(t *testing.T, dir string, maxBytes, segmentBytes int64) *Queue {
+	t.Helper()
+	logrus.SetOutput(io.Discard)
+	q, err := Open(dir, maxBytes, segmentBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// testTime 固定时间，每条记录长度固定（73字节）
+var testTime = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func appendN(t *testing.T, q *Queue, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		msg := &Message{Topic: "t", Payload: fmt.Sprintf("msg-%03d", i), Qos: 1, Timestamp: testTime}
+		if err := q.Append(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// consume 取出count条消息的载荷
+func consume(t *testing.T, q *Queue, count int) []string {
+	t.Helper()
+	var payloads []string
+	for i := 0; i < count; i++ {
+		msg, err := q.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg == nil {
+			break
+		}
+		payloads = append(payloads, msg.Payload)
+		if err := q.Ack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return payloads
+}
+
+func segmentFiles(t *testing.T, dir string) int {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+// TestQueueCursorRecovery 重新打开后从上次确认的位置继续，已确认的消息不重发
+func TestQueueCursorRecovery(t *testing.T) {
+	dir := t.TempDir()
+	q := openQueue(t, dir, 1<<20, 1<<20)
+	appendN(t, q, 0, 10)
+	if got := consume(t, q, 4); strings.Join(got, ",") != "msg-000,msg-001,msg-002,msg-003" {
+		t.Fatalf("消费到%v", got)
+	}
+	// Peek但未Ack的消息重新打开后仍然存在
+	if _, err := q.Peek(); err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+
+	q = openQueue(t, dir, 1<<20, 1<<20)
+	if q.Len() != 6 {
+		t.Fatalf("恢复后待重发%d条，期望6", q.Len())
+	}
+	// 恢复后继续写入，顺序排在旧消息之后
+	appendN(t, q, 10, 12)
+	got := consume(t, q, 100)
+	if len(got) != 8 || got[0] != "msg-004" || got[7] != "msg-011" {
+		t.Fatalf("恢复后消费到%v", got)
+	}
+	q.Close()
+}
+
+// TestQueueTruncatedTail 写入一半的尾部记录在重新打开时被截断
+func TestQueueTruncatedTail(t *testing.T) {
+	dir := t.TempDir()
+	q := openQueue(t, dir, 1<<20, 1<<20)
+	appendN(t, q, 0, 3)
+	q.Close()
+
+	path := q.segmentPath(1)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 50, '{'})
+	f.Close()
+
+	q = openQueue(t, dir, 1<<20, 1<<20)
+	defer q.Close()
+	appendN(t, q, 3, 4)
+	if got := consume(t, q, 100); strings.Join(got, ",") != "msg-000,msg-001,msg-002,msg-003" {
+		t.Fatalf("消费到%v", got)
+	}
+}
+
+// TestQueueSegmentRoll 段写满后滚动到新段，消费完的段被删除
+func TestQueueSegmentRoll(t *testing.T) {
+	dir := t.TempDir()
+	// 每段容纳2条
+	q := openQueue(t, dir, 1<<20, 200)
+	defer q.Close()
+	appendN(t, q, 0, 7)
+	if n := segmentFiles(t, dir); n != 4 {
+		t.Fatalf("段文件%d个，期望4", n)
+	}
+
+	if got := consume(t, q, 3); strings.Join(got, ",") != "msg-000,msg-001,msg-002" {
+		t.Fatalf("消费到%v", got)
+	}
+	if n := segmentFiles(t, dir); n != 3 {
+		t.Fatalf("消费完第一段后段文件%d个，期望3", n)
+	}
+	// 跨段恢复
+	q.Close()
+	q = openQueue(t, dir, 1<<20, 200)
+	if got := consume(t, q, 100); strings.Join(got, ",") != "msg-003,msg-004,msg-005,msg-006" {
+		t.Fatalf("恢复后消费到%v", got)
+	}
+	if n := segmentFiles(t, dir); n != 0 {
+		t.Fatalf("全部消费后段文件%d个，期望0", n)
+	}
+}
+
+// TestQueueDropOldest 超过max_bytes时丢弃最旧的段，保留最新的消息
+func TestQueueDropOldest(t *testing.T) {
+	dir := t.TempDir()
+	// 每段2条，总容量3段
+	q := openQueue(t, dir, 450, 200)
+	defer q.Close()
+	appendN(t, q, 0, 10)
+	if q.Len() != 6 {
+		t.Fatalf("待重发%d条，期望6", q.Len())
+	}
+	got := consume(t, q, 100)
+	if strings.Join(got, ",") != "msg-004,msg-005,msg-006,msg-007,msg-008,msg-009" {
+		t.Fatalf("消费到%v", got)
+	}
+}
@@ -0,0 +1,193 @@
+package regprofile
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// minEdgePatternLen 心跳包至少这么长才在读取块的开头和末尾剔除，更短的心跳包只在整块都是心跳包时剔除，
+	// 避免把拆包后恰好以这些字节开头或结尾的Modbus响应当作心跳包
+	minEdgePatternLen = 4
+	// heartbeatHoldTimeout 读取块是心跳包的开头时，等待剩余字节的最长时间，超时后按数据交出
+	heartbeatHoldTimeout = 50 * time.Millisecond
+	// filterReadSize 每次从底层连接读取的字节数，与调用方的缓冲区大小无关，保证读取块与DTU的发送包一致
+	filterReadSize = 1024
+)
+
+// heartbeatFilterConn 从读取的数据流中剔除心跳包
+// DTU的心跳包是单独发送的一包，只会出现在发送包之间（包括拆成两包的Modbus响应中间），不会出现在一包数据内部，
+// 因此只在每次底层读取得到的数据块边界上剔除完整的心跳包，不在数据中间查找，Modbus数据中与心跳包相同的字节不受影响：
+// 数据块开头和末尾的心跳包被剔除；短心跳包只在整块都是心跳包时剔除；
+// 整块是心跳包的开头时（心跳包被拆成多次读取）暂存，最多等待heartbeatHoldTimeout，等不到剩余字节时按数据交出
+type heartbeatFilterConn struct {
+	net.Conn
+	patterns [][]byte
+
+	mutex        sync.Mutex
+	pending      []byte    // 疑似被拆开的心跳包开头
+	ready        []byte    // 已过滤、待返回给调用方的数据
+	readDeadline time.Time // 调用方设置的读取截止时间
+}
+
+// NewHeartbeatFilter 包装连接，读取时剔除心跳包；没有心跳包配置时返回原连接
+func NewHeartbeatFilter(conn net.Conn, patterns [][]byte) net.Conn {
+	var valid [][]byte
+	for _, pattern := range patterns {
+		if len(pattern) > 0 {
+			valid = append(valid, pattern)
+		}
+	}
+	if len(valid) == 0 {
+		return conn
+	}
+	return &heartbeatFilterConn{Conn: conn, patterns: valid}
+}
+
+// SetDeadline 记录调用方的读取截止时间，暂存心跳包开头时据此恢复
+func (c *heartbeatFilterConn) SetDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.readDeadline = t
+	c.mutex.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline 记录调用方的读取截止时间，暂存心跳包开头时据此恢复
+func (c *heartbeatFilterConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.readDeadline = t
+	c.mutex.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *heartbeatFilterConn) Read(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	buf := make([]byte, filterReadSize)
+	for len(c.ready) == 0 {
+		// 暂存了心跳包开头时只等待一小段时间
+		holding := len(c.pending) > 0
+		var holdUntil time.Time
+		if holding {
+			holdUntil = time.Now().Add(heartbeatHoldTimeout)
+			if c.readDeadline.IsZero() || holdUntil.Before(c.readDeadline) {
+				c.Conn.SetReadDeadline(holdUntil)
+			}
+		}
+		n, err := c.Conn.Read(buf)
+		if holding {
+			c.Conn.SetReadDeadline(c.readDeadline)
+		}
+		if n > 0 {
+			chunk := append(c.pending, buf[:n]...)
+			c.pending = nil
+			c.filter(chunk)
+		}
+		if err != nil {
+			if holding && isTimeout(err) && (c.readDeadline.IsZero() || time.Now().Before(c.readDeadline)) {
+				// 等不到心跳包的剩余字节，暂存的数据不是心跳包
+				c.ready = append(c.ready, c.pending...)
+				c.pending = nil
+				continue
+			}
+			if len(c.ready) == 0 && len(c.pending) == 0 {
+				return 0, err
+			}
+			c.ready = append(c.ready, c.pending...)
+			c.pending = nil
+			break
+		}
+	}
+
+	n := copy(p, c.ready)
+	c.ready = c.ready[n:]
+	return n, nil
+}
+
+// filter 剔除数据块开头和末尾的完整心跳包，剩余数据交给调用方；整块是心跳包的开头时暂存
+func (c *heartbeatFilterConn) filter(chunk []byte) {
+	if count, ok := c.onlyHeartbeats(chunk); ok {
+		logrus.Debugf("已过滤心跳包 %d 个", count)
+		return
+	}
+
+	dropped := 0
+	for {
+		pattern := c.matchEdge(chunk, bytes.HasPrefix)
+		if pattern == nil {
+			break
+		}
+		chunk = chunk[len(pattern):]
+		dropped++
+	}
+	for {
+		pattern := c.matchEdge(chunk, bytes.HasSuffix)
+		if pattern == nil {
+			break
+		}
+		chunk = chunk[:len(chunk)-len(pattern)]
+		dropped++
+	}
+	if dropped > 0 {
+		logrus.Debugf("已过滤心跳包 %d 个", dropped)
+	}
+
+	if c.isPartialHeartbeat(chunk) {
+		c.pending = append([]byte(nil), chunk...)
+		return
+	}
+	c.ready = append(c.ready, chunk...)
+}
+
+// onlyHeartbeats 数据块是否全部由心跳包组成（任意长度的心跳包），返回心跳包个数
+func (c *heartbeatFilterConn) onlyHeartbeats(chunk []byte) (int, bool) {
+	count := 0
+	for len(chunk) > 0 {
+		matched := false
+		for _, pattern := range c.patterns {
+			if bytes.HasPrefix(chunk, pattern) {
+				chunk = chunk[len(pattern):]
+				count++
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return 0, false
+		}
+	}
+	return count, count > 0
+}
+
+// matchEdge 返回位于数据块边界（开头或末尾，由match判断）的长心跳包，没有时返回nil
+func (c *heartbeatFilterConn) matchEdge(chunk []byte, match func(s, prefix []byte) bool) []byte {
+	for _, pattern := range c.patterns {
+		if len(pattern) >= minEdgePatternLen && match(chunk, pattern) {
+			return pattern
+		}
+	}
+	return nil
+}
+
+// isPartialHeartbeat 整个数据块是否是某个心跳包的开头（不含完整心跳包）
+func (c *heartbeatFilterConn) isPartialHeartbeat(chunk []byte) bool {
+	if len(chunk) == 0 {
+		return false
+	}
+	for _, pattern := range c.patterns {
+		if len(chunk) < len(pattern) && bytes.HasPrefix(pattern, chunk) {
+			return true
+		}
+	}
+	return false
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
@@ -0,0 +1,135 @@
+package regprofile
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// filterPipe 返回经过心跳过滤的读端，写端每次Write对应DTU发送的一包
+func filterPipe(t *testing.T, patterns ...string) (net.Conn, net.Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	var raw [][]byte
+	for _, pattern := range patterns {
+		raw = append(raw, []byte(pattern))
+	}
+	return NewHeartbeatFilter(server, raw), client
+}
+
+// sendPackets 依次发送每一包，发送完后关闭写端
+func sendPackets(dtu net.Conn, packets ...[]byte) {
+	go func() {
+		for _, packet := range packets {
+			if _, err := dtu.Write(packet); err != nil {
+				return
+			}
+		}
+		dtu.Close()
+	}()
+}
+
+// TestHeartbeatBytesInsidePayloadKept Modbus数据中与心跳包相同的字节不被剔除
+func TestHeartbeatBytesInsidePayloadKept(t *testing.T) {
+	cases := []struct {
+		name    string
+		pattern string
+		packet  []byte
+	}{
+		{"短心跳包在数据中间", "HB", []byte{0x01, 0x03, 0x02, 0x48, 0x42, 0x11, 0x22}},
+		{"长心跳包在数据中间", "DTU1", []byte{0x01, 0x03, 0x04, 'D', 'T', 'U', '1', 0x11, 0x22}},
+		{"短心跳包在数据开头", "HB", []byte{0x48, 0x42, 0x02, 0x11, 0x22}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn, dtu := filterPipe(t, c.pattern)
+			sendPackets(dtu, c.packet)
+			got, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, c.packet) {
+				t.Fatalf("读到% X，期望% X", got, c.packet)
+			}
+		})
+	}
+}
+
+// TestHeartbeatAtPacketBoundaries 单独一包、包开头和包末尾的心跳包被剔除，拆成两包的心跳包也被剔除
+func TestHeartbeatAtPacketBoundaries(t *testing.T) {
+	resp1 := []byte{0x01, 0x03, 0x02, 0x00, 0x2A, 0x39, 0x9B}
+	resp2 := []byte{0x02, 0x03, 0x02, 0x00, 0x2B, 0x3D, 0x8B}
+	conn, dtu := filterPipe(t, "REG12345", "HB")
+	sendPackets(dtu,
+		[]byte("REG12345"),
+		[]byte("HBHB"),
+		append([]byte("REG12345"), resp1[:3]...),
+		resp1[3:],
+		[]byte("REG1"),
+		[]byte("2345"),
+		append(append([]byte(nil), resp2...), "REG12345"...),
+	)
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]byte(nil), resp1...), resp2...)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("读到% X，期望% X", got, want)
+	}
+}
+
+// TestTrailingHeartbeatPrefixNotHeld 响应末尾字节恰好是心跳包开头时立即交出，不等到读取超时
+func TestTrailingHeartbeatPrefixNotHeld(t *testing.T) {
+	conn, dtu := filterPipe(t, "HB")
+	packet := []byte{0x01, 0x03, 0x02, 0x00, 0x2A, 0x39, 'H'}
+	go dtu.Write(packet)
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	start := time.Now()
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], packet) {
+		t.Fatalf("读到% X，期望% X", buf[:n], packet)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("读取耗时%v", elapsed)
+	}
+}
+
+// TestPartialHeartbeatReleasedAfterTimeout 整包是心跳包开头但等不到剩余字节时，短暂等待后按数据交出
+func TestPartialHeartbeatReleasedAfterTimeout(t *testing.T) {
+	conn, dtu := filterPipe(t, "HB")
+	go dtu.Write([]byte{'H'})
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	start := time.Now()
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "H" {
+		t.Fatalf("读到% X，期望48", buf[:n])
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("读取耗时%v，应在心跳包等待超时后交出", elapsed)
+	}
+
+	// 调用方的截止时间恢复生效
+	if _, err := conn.Read(buf); !isTimeout(err) {
+		t.Fatalf("期望读取超时，得到%v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Fatalf("调用方的截止时间未恢复: %v后超时", elapsed)
+	}
+}
@@ -0,0 +1,205 @@
+package regprofile
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// 注册包格式
+const (
+	FormatASCII  = "ascii"  // 整个注册包按ASCII作为ID
+	FormatHex    = "hex"    // 整个注册包按十六进制字符串作为ID
+	FormatFixed  = "fixed"  // 固定长度的注册包
+	FormatPrefix = "prefix" // 前缀+ID+后缀
+	FormatRegex  = "regex"  // 正则提取ID（如IMEI、ICCID）
+)
+
+// 心跳包配置的特殊值
+const (
+	HeartbeatRegister = "register" // 心跳包与注册包相同（默认）
+	HeartbeatNone     = "none"     // 不过滤心跳包
+)
+
+// Profile 注册包和心跳包格式
+type Profile struct {
+	Name      string `mapstructure:"name"`
+	Format    string `mapstructure:"format"`    // ascii、hex、fixed、prefix、regex
+	Encoding  string `mapstructure:"encoding"`  // ID的编码：ascii（默认）或hex（大写十六进制）
+	Length    int    `mapstructure:"length"`    // fixed：注册包长度（字节）
+	Prefix    string `mapstructure:"prefix"`    // prefix：前缀，"hex:"开头表示十六进制
+	Suffix    string `mapstructure:"suffix"`    // prefix：后缀，"hex:"开头表示十六进制
+	Pattern   string `mapstructure:"pattern"`   // regex：提取ID的正则，有分组时取第一个分组
+	Heartbeat string `mapstructure:"heartbeat"` // 心跳包：register（默认）、none 或固定内容（"hex:"开头表示十六进制）
+
+	prefix    []byte
+	suffix    []byte
+	heartbeat []byte
+	regex     *regexp.Regexp
+}
+
+// DefaultProfiles 未配置时的默认格式：整个注册包按ASCII作为ID，心跳包与注册包相同
+func DefaultProfiles() []*Profile {
+	profile := &Profile{Name: "ascii", Format: FormatASCII}
+	profile.init()
+	return []*Profile{profile}
+}
+
+// LoadProfiles 从配置读取注册包格式列表，配置为空或全部无效时返回默认格式
+func LoadProfiles(key string) []*Profile {
+	var profiles []*Profile
+	if err := viper.UnmarshalKey(key, &profiles); err != nil {
+		logrus.Errorf("注册包格式配置解析失败，使用默认格式: %v", err)
+		return DefaultProfiles()
+	}
+
+	var valid []*Profile
+	for i, profile := range profiles {
+		if profile == nil {
+			continue
+		}
+		if profile.Name == "" {
+			profile.Name = fmt.Sprintf("profile-%d", i+1)
+		}
+		if err := profile.init(); err != nil {
+			logrus.Errorf("注册包格式 %s 配置无效，已忽略: %v", profile.Name, err)
+			continue
+		}
+		valid = append(valid, profile)
+	}
+	if len(valid) == 0 {
+		return DefaultProfiles()
+	}
+	for _, profile := range valid {
+		logrus.Infof("注册包格式: name=%s, format=%s, encoding=%s, heartbeat=%s", profile.Name, profile.Format, profile.Encoding, profile.Heartbeat)
+	}
+	return valid
+}
+
+// init 校验配置并预处理
+func (p *Profile) init() error {
+	p.Format = strings.ToLower(strings.TrimSpace(p.Format))
+	if p.Format == "" {
+		p.Format = FormatASCII
+	}
+	p.Encoding = strings.ToLower(strings.TrimSpace(p.Encoding))
+	if p.Format == FormatHex {
+		p.Encoding = FormatHex
+	}
+	if p.Encoding == "" {
+		p.Encoding = FormatASCII
+	}
+	if p.Encoding != FormatASCII && p.Encoding != FormatHex {
+		return fmt.Errorf("不支持的编码: %s", p.Encoding)
+	}
+
+	var err error
+	switch p.Format {
+	case FormatASCII, FormatHex:
+	case FormatFixed:
+		if p.Length <= 0 {
+			return fmt.Errorf("fixed格式需要配置length")
+		}
+	case FormatPrefix:
+		if p.prefix, err = ParseBytes(p.Prefix); err != nil {
+			return fmt.Errorf("prefix无效: %v", err)
+		}
+		if p.suffix, err = ParseBytes(p.Suffix); err != nil {
+			return fmt.Errorf("suffix无效: %v", err)
+		}
+		if len(p.prefix) == 0 && len(p.suffix) == 0 {
+			return fmt.Errorf("prefix格式需要配置prefix或suffix")
+		}
+	case FormatRegex:
+		if p.regex, err = regexp.Compile(p.Pattern); err != nil {
+			return fmt.Errorf("pattern无效: %v", err)
+		}
+	default:
+		return fmt.Errorf("不支持的格式: %s", p.Format)
+	}
+
+	if p.Heartbeat == "" {
+		p.Heartbeat = HeartbeatRegister
+	}
+	if p.Heartbeat != HeartbeatRegister && p.Heartbeat != HeartbeatNone {
+		if p.heartbeat, err = ParseBytes(p.Heartbeat); err != nil {
+			return fmt.Errorf("heartbeat无效: %v", err)
+		}
+	}
+	return nil
+}
+
+// Extract 从注册包中提取网关ID，注册包不符合该格式时返回false
+func (p *Profile) Extract(raw []byte) (string, bool) {
+	if len(raw) == 0 {
+		return "", false
+	}
+	switch p.Format {
+	case FormatASCII, FormatHex:
+		return p.encode(raw), true
+	case FormatFixed:
+		if len(raw) != p.Length {
+			return "", false
+		}
+		return p.encode(raw), true
+	case FormatPrefix:
+		if !bytes.HasPrefix(raw, p.prefix) || !bytes.HasSuffix(raw, p.suffix) || len(raw) <= len(p.prefix)+len(p.suffix) {
+			return "", false
+		}
+		return p.encode(raw[len(p.prefix) : len(raw)-len(p.suffix)]), true
+	case FormatRegex:
+		match := p.regex.FindStringSubmatch(p.encode(raw))
+		if match == nil {
+			return "", false
+		}
+		id := match[0]
+		if len(match) > 1 {
+			id = match[1]
+		}
+		return id, id != ""
+	}
+	return "", false
+}
+
+// HeartbeatPatterns 该网关需要从数据流中过滤的心跳包
+func (p *Profile) HeartbeatPatterns(raw []byte) [][]byte {
+	switch p.Heartbeat {
+	case HeartbeatNone:
+		return nil
+	case HeartbeatRegister:
+		return [][]byte{append([]byte(nil), raw...)}
+	default:
+		return [][]byte{append([]byte(nil), p.heartbeat...)}
+	}
+}
+
+func (p *Profile) encode(data []byte) string {
+	if p.Encoding == FormatHex {
+		return strings.ToUpper(hex.EncodeToString(data))
+	}
+	return string(data)
+}
+
+// Identify 按顺序匹配注册包格式，返回第一个匹配的格式和提取的网关ID
+func Identify(profiles []*Profile, raw []byte) (*Profile, string, bool) {
+	for _, profile := range profiles {
+		if id, ok := profile.Extract(raw); ok {
+			return profile, id, true
+		}
+	}
+	return nil, "", false
+}
+
+// ParseBytes 解析配置中的字节内容，"hex:"开头表示十六进制，否则按ASCII
+func ParseBytes(s string) ([]byte, error) {
+	if strings.HasPrefix(strings.ToLower(s), "hex:") {
+		h := strings.NewReplacer(" ", "", "-", "", ":", "").Replace(s[4:])
+		return hex.DecodeString(h)
+	}
+	return []byte(s), nil
+}
@@ -0,0 +1,97 @@
+package serverconfig
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// TLSConfig 监听器的TLS配置
+type TLSConfig struct {
+	Enabled        bool   `mapstructure:"enabled"`
+	CertFile       string `mapstructure:"cert_file"`          // 服务端证书
+	KeyFile        string `mapstructure:"key_file"`           // 服务端私钥
+	ClientCAFile   string `mapstructure:"client_ca_file"`     // 客户端CA证书，配置后要求客户端证书
+	CertCNAsRegPkg bool   `mapstructure:"cert_cn_as_reg_pkg"` // 以客户端证书CN作为注册包
+	// RegPkgWait 以证书CN作为注册包时，等待DTU仍然发送的注册包的时间，超时后开始采集
+	RegPkgWait time.Duration `mapstructure:"reg_pkg_wait"`
+}
+
+// Listener 监听器配置
+type Listener struct {
+	Name                 string    `mapstructure:"name"`
+	Address              string    `mapstructure:"address"`
+	Protocol             string    `mapstructure:"protocol"`              // 默认协议类型，平台网关配置未指定时使用
+	Identifier           string    `mapstructure:"identifier"`            // 服务标识符，按标识符上报服务心跳
+	RegistrationProfiles []string  `mapstructure:"registration_profiles"` // 使用的注册包格式名称，为空时使用全部格式
+	TLS                  TLSConfig `mapstructure:"tls"`
+}
+
+// Listeners 读取监听器列表（server.listeners）
+// 未配置时按旧配置生成：server.address明文监听，server.tls.enabled时增加TLS监听
+func Listeners() []Listener {
+	if viper.IsSet("server.listeners") {
+		var listeners []Listener
+		if err := viper.UnmarshalKey("server.listeners", &listeners); err != nil {
+			logrus.Errorf("监听器配置解析失败: %v", err)
+			return nil
+		}
+		for i := range listeners {
+			if listeners[i].Name == "" {
+				listeners[i].Name = fmt.Sprintf("listener-%d", i+1)
+			}
+		}
+		return listeners
+	}
+
+	listeners := []Listener{{
+		Name:       "default",
+		Address:    viper.GetString("server.address"),
+		Identifier: viper.GetString("server.identifier1"),
+	}}
+	if viper.GetBool("server.tls.enabled") {
+		address := viper.GetString("server.tls.address")
+		if address == "" {
+			address = "0.0.0.0:802"
+		}
+		listeners = append(listeners, Listener{
+			Name:    "tls",
+			Address: address,
+			TLS: TLSConfig{
+				Enabled:        true,
+				CertFile:       viper.GetString("server.tls.cert_file"),
+				KeyFile:        viper.GetString("server.tls.key_file"),
+				ClientCAFile:   viper.GetString("server.tls.client_ca_file"),
+				CertCNAsRegPkg: viper.GetBool("server.tls.cert_cn_as_reg_pkg"),
+				RegPkgWait:     viper.GetDuration("server.tls.reg_pkg_wait"),
+			},
+		})
+	}
+	return listeners
+}
+
+// Identifiers 需要上报服务心跳的标识符，去重
+// 使用旧配置时为server.identifier1和server.identifier2
+func Identifiers() []string {
+	var candidates []string
+	if viper.IsSet("server.listeners") {
+		for _, listener := range Listeners() {
+			candidates = append(candidates, listener.Identifier)
+		}
+	} else {
+		candidates = []string{viper.GetString("server.identifier1"), viper.GetString("server.identifier2")}
+	}
+
+	seen := make(map[string]bool)
+	var identifiers []string
+	for _, identifier := range candidates {
+		if identifier == "" || seen[identifier] {
+			continue
+		}
+		seen[identifier] = true
+		identifiers = append(identifiers, identifier)
+	}
+	return identifiers
+}
@@ -0,0 +1,59 @@
+package serverconfig
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// OutboundTLSConfig 主动连接的TLS配置（Modbus/TCP Security）
+type OutboundTLSConfig struct {
+	Enabled            bool   `mapstructure:"enabled"`
+	CAFile             string `mapstructure:"ca_file"`              // 校验服务端证书的CA证书，为空使用系统根证书
+	CertFile           string `mapstructure:"cert_file"`            // 客户端证书，Modbus/TCP Security要求双向认证
+	KeyFile            string `mapstructure:"key_file"`             // 客户端私钥
+	ServerName         string `mapstructure:"server_name"`          // 校验服务端证书使用的名称，为空时使用地址中的主机名
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"` // 不校验服务端证书，仅用于测试
+}
+
+// Outbound 主动连接的Modbus/TCP服务端（如PLC、带TCP接口的仪表）
+// 插件作为客户端连接并采集，连接后的处理与DTU连接相同，注册包由配置指定
+type Outbound struct {
+	Name              string            `mapstructure:"name"`
+	Address           string            `mapstructure:"address"`            // 服务端地址，Modbus/TCP Security默认端口802
+	RegPkg            string            `mapstructure:"reg_pkg"`            // 注册包，按注册包向平台获取网关配置
+	Protocol          string            `mapstructure:"protocol"`           // 默认协议类型，平台网关配置未指定时使用，默认MODBUS_TCP
+	DialTimeout       time.Duration     `mapstructure:"dial_timeout"`       // 连接（含TLS握手）超时，默认10秒
+	ReconnectInterval time.Duration     `mapstructure:"reconnect_interval"` // 连接失败或断开后的重连间隔，默认10秒
+	TLS               OutboundTLSConfig `mapstructure:"tls"`
+}
+
+// OutboundTargets 读取主动连接列表（server.outbound）
+func OutboundTargets() []Outbound {
+	if !viper.IsSet("server.outbound") {
+		return nil
+	}
+	var targets []Outbound
+	if err := viper.UnmarshalKey("server.outbound", &targets); err != nil {
+		logrus.Errorf("主动连接配置解析失败: %v", err)
+		return nil
+	}
+	for i := range targets {
+		target := &targets[i]
+		if target.Name == "" {
+			target.Name = fmt.Sprintf("outbound-%d", i+1)
+		}
+		if target.Protocol == "" {
+			target.Protocol = "MODBUS_TCP"
+		}
+		if target.DialTimeout <= 0 {
+			target.DialTimeout = 10 * time.Second
+		}
+		if target.ReconnectInterval <= 0 {
+			target.ReconnectInterval = 10 * time.Second
+		}
+	}
+	return targets
+}
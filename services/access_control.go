@@ -0,0 +1,363 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// IPBan IP封禁记录
+type IPBan struct {
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+	Until     time.Time `json:"until"`
+}
+
+// AccessControl IP访问控制（access_control配置）
+// 统一处理CIDR白名单/黑名单、认证失败自动封禁和手动解封，封禁记录持久化到文件，重启后仍然有效
+type AccessControl struct {
+	enabled     bool          // 是否根据认证失败自动封禁，CIDR规则和已有封禁始终生效
+	threshold   int           // 连续失败次数达到后封禁
+	banDuration time.Duration // 自动封禁时长
+	allow       []*net.IPNet  // 白名单，非空时只允许名单内的IP
+	deny        []*net.IPNet  // 黑名单
+	persistPath string        // 封禁记录文件，为空不持久化
+
+	mutex       sync.Mutex
+	failures    map[string]*failureRecord // IP -> 失败记录
+	bans        map[string]*IPBan         // IP -> 封禁记录
+	lastLogTime map[string]time.Time      // IP -> 上次拒绝日志时间
+}
+
+// failureRecord 连续认证失败记录
+type failureRecord struct {
+	count  int
+	lastAt time.Time
+}
+
+// NewAccessControl 根据配置创建访问控制，兼容旧的auth_limiter配置
+func NewAccessControl() *AccessControl {
+	enabled := true // 默认启用
+	if key := accessControlKey("enabled", "enabled"); viper.IsSet(key) {
+		enabled = viper.GetBool(key)
+	}
+	threshold := viper.GetInt(accessControlKey("failure_threshold", "failure_threshold"))
+	if threshold <= 0 {
+		threshold = 3 // 默认3次
+	}
+	banDuration := viper.GetDuration(accessControlKey("ban_duration", "block_duration"))
+	if banDuration <= 0 {
+		banDuration = 3 * time.Minute // 默认3分钟
+	}
+
+	ac := &AccessControl{
+		enabled:     enabled,
+		threshold:   threshold,
+		banDuration: banDuration,
+		allow:       parseCIDRList(viper.GetStringSlice("access_control.allow")),
+		deny:        parseCIDRList(viper.GetStringSlice("access_control.deny")),
+		persistPath: viper.GetString("access_control.persist_file"),
+		failures:    make(map[string]*failureRecord),
+		bans:        make(map[string]*IPBan),
+		lastLogTime: make(map[string]time.Time),
+	}
+	ac.load()
+
+	logrus.Infof("IP访问控制初始化: enabled=%v, threshold=%d, banDuration=%v, allow=%d条, deny=%d条, 已有封禁=%d",
+		ac.enabled, ac.threshold, ac.banDuration, len(ac.allow), len(ac.deny), len(ac.bans))
+	return ac
+}
+
+// accessControlKey 优先使用access_control配置，未配置时使用旧的auth_limiter配置
+func accessControlKey(name string, legacyName string) string {
+	key := "access_control." + name
+	if !viper.IsSet(key) && viper.IsSet("auth_limiter."+legacyName) {
+		return "auth_limiter." + legacyName
+	}
+	return key
+}
+
+// parseCIDRList 解析CIDR列表，单个IP视为/32或/128
+func parseCIDRList(items []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			logrus.Errorf("无效的CIDR配置，已忽略: %s, err=%v", item, err)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IP访问受限的原因，封禁时为封禁原因
+const (
+	AccessDenyList   = "deny list"
+	AccessNotAllowed = "not in allow list"
+)
+
+// Check 检查IP是否允许连接，不允许时返回原因
+func (ac *AccessControl) Check(ip string) (bool, string) {
+	parsed := net.ParseIP(ip)
+	if parsed != nil {
+		if containsIP(ac.deny, parsed) {
+			return false, AccessDenyList
+		}
+		if len(ac.allow) > 0 && !containsIP(ac.allow, parsed) {
+			return false, AccessNotAllowed
+		}
+	}
+
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	ban, ok := ac.bans[ip]
+	if !ok {
+		return true, ""
+	}
+	if time.Now().Before(ban.Until) {
+		return false, ban.Reason
+	}
+	// 封禁过期，清理记录
+	delete(ac.bans, ip)
+	delete(ac.failures, ip)
+	delete(ac.lastLogTime, ip)
+	ac.saveLocked()
+	return true, ""
+}
+
+// RecordFailure 记录认证失败，连续失败达到阈值后封禁
+func (ac *AccessControl) RecordFailure(ip string, reason string) {
+	if !ac.enabled {
+		return
+	}
+
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+
+	record, ok := ac.failures[ip]
+	if !ok {
+		record = &failureRecord{}
+		ac.failures[ip] = record
+	}
+	record.count++
+	record.lastAt = time.Now()
+	if record.count >= ac.threshold {
+		ac.banLocked(ip, ac.banDuration, fmt.Sprintf("%s (连续失败%d次)", reason, record.count))
+		logrus.Warnf("IP认证限流触发: IP=%s, 失败次数=%d, 原因=%s, 封禁时间=%v", ip, record.count, reason, ac.banDuration)
+	}
+}
+
+// RecordSuccess 记录认证成功，清除失败计数
+func (ac *AccessControl) RecordSuccess(ip string) {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	delete(ac.failures, ip)
+	delete(ac.lastLogTime, ip)
+}
+
+// Ban 手动封禁IP
+func (ac *AccessControl) Ban(ip string, duration time.Duration, reason string) {
+	if duration <= 0 {
+		duration = ac.banDuration
+	}
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	ac.banLocked(ip, duration, reason)
+}
+
+func (ac *AccessControl) banLocked(ip string, duration time.Duration, reason string) {
+	now := time.Now()
+	ac.bans[ip] = &IPBan{IP: ip, Reason: reason, CreatedAt: now, Until: now.Add(duration)}
+	ac.saveLocked()
+}
+
+// Unban 解除封禁并清除失败计数，IP未被封禁时返回false
+func (ac *AccessControl) Unban(ip string) bool {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	_, ok := ac.bans[ip]
+	delete(ac.bans, ip)
+	delete(ac.failures, ip)
+	delete(ac.lastLogTime, ip)
+	if ok {
+		ac.saveLocked()
+		logrus.Infof("IP已解封: %s", ip)
+	}
+	return ok
+}
+
+// Bans 当前生效的封禁列表，按解封时间排序
+func (ac *AccessControl) Bans() []IPBan {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	now := time.Now()
+	bans := make([]IPBan, 0, len(ac.bans))
+	for _, ban := range ac.bans {
+		if now.Before(ban.Until) {
+			bans = append(bans, *ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Until.Before(bans[j].Until) })
+	return bans
+}
+
+// ShouldLogReject 限制拒绝日志频率：同一IP每分钟最多1条
+func (ac *AccessControl) ShouldLogReject(ip string) bool {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	now := time.Now()
+	if last, ok := ac.lastLogTime[ip]; ok && now.Sub(last) < time.Minute {
+		return false
+	}
+	ac.lastLogTime[ip] = now
+	return true
+}
+
+// cleanupLoop 定期清理过期的封禁和长时间没有新失败的计数
+func (ac *AccessControl) cleanupLoop(interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ac.cleanup()
+	}
+}
+
+func (ac *AccessControl) cleanup() {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	now := time.Now()
+	changed := false
+	for ip, ban := range ac.bans {
+		if !now.Before(ban.Until) {
+			delete(ac.bans, ip)
+			changed = true
+		}
+	}
+	for ip, record := range ac.failures {
+		if _, banned := ac.bans[ip]; !banned && now.Sub(record.lastAt) > ac.banDuration {
+			delete(ac.failures, ip)
+		}
+	}
+	for ip, last := range ac.lastLogTime {
+		if now.Sub(last) > time.Minute {
+			delete(ac.lastLogTime, ip)
+		}
+	}
+	if changed {
+		ac.saveLocked()
+	}
+}
+
+// load 从文件恢复未过期的封禁记录
+func (ac *AccessControl) load() {
+	if ac.persistPath == "" {
+		return
+	}
+	data, err := os.ReadFile(ac.persistPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Warnf("读取IP封禁记录失败: %v", err)
+		}
+		return
+	}
+	var bans []IPBan
+	if err := json.Unmarshal(data, &bans); err != nil {
+		logrus.Warnf("解析IP封禁记录失败: %v", err)
+		return
+	}
+	now := time.Now()
+	for i := range bans {
+		if now.Before(bans[i].Until) {
+			ac.bans[bans[i].IP] = &bans[i]
+		}
+	}
+}
+
+// saveLocked 将封禁记录写入文件，调用方需持有锁
+func (ac *AccessControl) saveLocked() {
+	if ac.persistPath == "" {
+		return
+	}
+	bans := make([]IPBan, 0, len(ac.bans))
+	for _, ban := range ac.bans {
+		bans = append(bans, *ban)
+	}
+	data, err := json.Marshal(bans)
+	if err != nil {
+		logrus.Warnf("序列化IP封禁记录失败: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(ac.persistPath), 0755); err != nil {
+		logrus.Warnf("创建IP封禁记录目录失败: %v", err)
+		return
+	}
+	// 先写临时文件再重命名，避免写一半
+	tmp := ac.persistPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		logrus.Warnf("写入IP封禁记录失败: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, ac.persistPath); err != nil {
+		logrus.Warnf("写入IP封禁记录失败: %v", err)
+	}
+}
+
+// remoteIP 连接的对端IP，兼容IPv6
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// Bans 当前生效的IP封禁列表
+func Bans() []IPBan {
+	if accessControl == nil {
+		return nil
+	}
+	return accessControl.Bans()
+}
+
+// UnbanIP 解除IP封禁
+func UnbanIP(ip string) bool {
+	if accessControl == nil {
+		return false
+	}
+	return accessControl.Unban(ip)
+}
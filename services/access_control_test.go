@@ -0,0 +1,134 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// setupAccessControlTest 每个测试使用独立的配置和封禁记录文件
+func setupAccessControlTest(t *testing.T) string {
+	t.Helper()
+	logrus.SetOutput(io.Discard)
+	path := filepath.Join(t.TempDir(), "access_control.json")
+	viper.Reset()
+	viper.Set("access_control.enabled", true)
+	viper.Set("access_control.failure_threshold", 3)
+	viper.Set("access_control.ban_duration", "1h")
+	viper.Set("access_control.persist_file", path)
+	t.Cleanup(viper.Reset)
+	return path
+}
+
+// TestAccessControlConcurrent 多个连接并发记录失败、检查、解封，用-race运行检查数据竞争
+func TestAccessControlConcurrent(t *testing.T) {
+	setupAccessControlTest(t)
+	ac := NewAccessControl()
+
+	const ips = 20
+	const workers = 8
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < ips; i++ {
+				ip := fmt.Sprintf("10.0.0.%d", i)
+				ac.RecordFailure(ip, "authentication failed")
+				ac.Check(ip)
+				ac.ShouldLogReject(ip)
+				if w == 0 && i%5 == 0 {
+					ac.Bans()
+					ac.cleanup()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// 每个IP都失败了workers次，超过阈值，全部被封禁
+	for i := 0; i < ips; i++ {
+		ip := fmt.Sprintf("10.0.0.%d", i)
+		if allowed, _ := ac.Check(ip); allowed {
+			t.Fatalf("%s 应该被封禁", ip)
+		}
+	}
+	if got := len(ac.Bans()); got != ips {
+		t.Fatalf("封禁数量=%d, 期望%d", got, ips)
+	}
+
+	// 并发解封，每个IP只有一次解封成功
+	var unbanned sync.Map
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < ips; i++ {
+				ip := fmt.Sprintf("10.0.0.%d", i)
+				if ac.Unban(ip) {
+					if _, loaded := unbanned.LoadOrStore(ip, true); loaded {
+						t.Errorf("%s 被重复解封", ip)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got := len(ac.Bans()); got != 0 {
+		t.Fatalf("解封后仍有%d条封禁", got)
+	}
+}
+
+// TestAccessControlPersistAndExpire 封禁记录在重启后恢复，过期的记录不再生效
+func TestAccessControlPersistAndExpire(t *testing.T) {
+	setupAccessControlTest(t)
+	ac := NewAccessControl()
+	ac.Ban("192.168.1.10", time.Hour, "manual")
+	ac.Ban("192.168.1.11", 50*time.Millisecond, "manual")
+
+	restored := NewAccessControl()
+	if allowed, _ := restored.Check("192.168.1.10"); allowed {
+		t.Fatal("重启后封禁记录丢失")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if allowed, _ := restored.Check("192.168.1.11"); !allowed {
+		t.Fatal("过期的封禁仍然生效")
+	}
+	if bans := restored.Bans(); len(bans) != 1 || bans[0].IP != "192.168.1.10" {
+		t.Fatalf("封禁列表不正确: %+v", bans)
+	}
+}
+
+// TestAccessControlCIDR 黑名单优先，白名单非空时只允许名单内的IP；关闭自动封禁后规则仍然生效
+func TestAccessControlCIDR(t *testing.T) {
+	setupAccessControlTest(t)
+	viper.Set("access_control.enabled", false)
+	viper.Set("access_control.allow", []string{"10.0.0.0/8", "192.168.1.10"})
+	viper.Set("access_control.deny", []string{"10.1.0.0/16"})
+	ac := NewAccessControl()
+
+	cases := map[string]bool{
+		"10.0.0.1":     true,
+		"10.1.2.3":     false,
+		"192.168.1.10": true,
+		"192.168.1.11": false,
+	}
+	for ip, want := range cases {
+		if allowed, reason := ac.Check(ip); allowed != want {
+			t.Errorf("Check(%s)=%v(%s), 期望%v", ip, allowed, reason, want)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		ac.RecordFailure("10.0.0.1", "authentication failed")
+	}
+	if allowed, _ := ac.Check("10.0.0.1"); !allowed {
+		t.Fatal("关闭自动封禁后不应封禁")
+	}
+}
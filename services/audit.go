@@ -0,0 +1,55 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// 审计日志文件，按行写入JSON（audit.file配置）
+var (
+	auditMutex sync.Mutex
+	auditFile  *os.File
+)
+
+// auditLog 记录审计事件：同时写入主日志和审计日志文件，文件不可用时只写主日志
+func auditLog(event string, fields map[string]interface{}) {
+	logrus.WithFields(logrus.Fields(fields)).Warnf("【审计】%s", event)
+
+	record := make(map[string]interface{}, len(fields)+2)
+	for key, value := range fields {
+		record[key] = value
+	}
+	record["event"] = event
+	record["time"] = time.Now().Format(time.RFC3339Nano)
+	data, err := json.Marshal(record)
+	if err != nil {
+		logrus.Warnf("序列化审计日志失败: %v", err)
+		return
+	}
+
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	if auditFile == nil {
+		path := viper.GetString("audit.file")
+		if path == "" {
+			path = "./data/audit.log"
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			logrus.Warnf("创建审计日志目录失败: %v", err)
+			return
+		}
+		if auditFile, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+			logrus.Warnf("打开审计日志失败: %v", err)
+			return
+		}
+	}
+	if _, err := auditFile.Write(append(data, '\n')); err != nil {
+		logrus.Warnf("写入审计日志失败: %v", err)
+	}
+}
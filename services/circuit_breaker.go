@@ -0,0 +1,149 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// 熔断器状态
+const (
+	BreakerClosed = "closed" // 正常采集
+	BreakerOpen   = "open"   // 熔断，仅按探测间隔采集
+)
+
+// slaveBreaker 单个从站的熔断状态
+type slaveBreaker struct {
+	timeouts  int       // 连续超时次数
+	open      bool      // 是否熔断
+	nextProbe time.Time // 熔断时下次允许探测的时间
+}
+
+// CircuitBreaker 从站熔断器
+// 同一网关下某个从站连续超时达到阈值后熔断，熔断期间只按探测间隔采集一次，避免拖慢整条总线
+type CircuitBreaker struct {
+	breakers      map[string]*slaveBreaker // 网关ID/从站地址 -> 熔断状态
+	mutex         sync.Mutex
+	enabled       bool
+	threshold     int           // 连续超时阈值
+	probeInterval time.Duration // 熔断时的探测间隔
+}
+
+// NewCircuitBreaker 创建从站熔断器
+func NewCircuitBreaker() *CircuitBreaker {
+	enabled := viper.GetBool("circuit_breaker.enabled")
+
+	threshold := viper.GetInt("circuit_breaker.failure_threshold")
+	if threshold <= 0 {
+		threshold = 3 // 默认连续超时3次
+	}
+
+	probeInterval := viper.GetDuration("circuit_breaker.probe_interval")
+	if probeInterval <= 0 {
+		probeInterval = time.Minute // 默认1分钟探测一次
+	}
+
+	logrus.Infof("从站熔断器初始化: enabled=%v, threshold=%d, probeInterval=%v", enabled, threshold, probeInterval)
+
+	return &CircuitBreaker{
+		breakers:      make(map[string]*slaveBreaker),
+		enabled:       enabled,
+		threshold:     threshold,
+		probeInterval: probeInterval,
+	}
+}
+
+// Allow 判断本次是否允许采集该从站，返回 (allow, probe)；probe为true表示熔断期间的探测
+func (cb *CircuitBreaker) Allow(gatewayID string, slaveID uint8) (bool, bool) {
+	if !cb.enabled {
+		return true, false
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	breaker, ok := cb.breakers[breakerKey(gatewayID, slaveID)]
+	if !ok || !breaker.open {
+		return true, false
+	}
+	now := time.Now()
+	if now.Before(breaker.nextProbe) {
+		return false, false
+	}
+	breaker.nextProbe = now.Add(cb.probeInterval)
+	return true, true
+}
+
+// RecordResult 记录采集结果，返回熔断器是否刚刚打开
+// 只有超时计入失败；从站有应答（包括异常响应）即恢复；连接错误属于网关问题，不影响从站熔断
+func (cb *CircuitBreaker) RecordResult(gatewayID string, slaveID uint8, err error) bool {
+	if !cb.enabled {
+		return false
+	}
+
+	var errType ErrorType = -1
+	if err != nil {
+		errType = ClassifyError(err).Type
+	}
+	if errType == ErrorTypeConnection {
+		return false
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	key := breakerKey(gatewayID, slaveID)
+	breaker, ok := cb.breakers[key]
+	if !ok {
+		breaker = &slaveBreaker{}
+		cb.breakers[key] = breaker
+	}
+
+	if errType != ErrorTypeTimeout {
+		if breaker.open {
+			logrus.Infof("从站恢复应答，关闭熔断: gatewayID=%s, slaveID=%d", gatewayID, slaveID)
+		}
+		breaker.timeouts = 0
+		breaker.open = false
+		return false
+	}
+
+	breaker.timeouts++
+	if breaker.open || breaker.timeouts < cb.threshold {
+		return false
+	}
+	breaker.open = true
+	breaker.nextProbe = time.Now().Add(cb.probeInterval)
+	logrus.Warnf("从站连续超时%d次，打开熔断: gatewayID=%s, slaveID=%d, 探测间隔=%v", breaker.timeouts, gatewayID, slaveID, cb.probeInterval)
+	return true
+}
+
+// State 获取从站熔断状态
+func (cb *CircuitBreaker) State(gatewayID string, slaveID uint8) string {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if breaker, ok := cb.breakers[breakerKey(gatewayID, slaveID)]; ok && breaker.open {
+		return BreakerOpen
+	}
+	return BreakerClosed
+}
+
+// RemoveGateway 网关断开时清除其下所有从站的熔断状态
+func (cb *CircuitBreaker) RemoveGateway(gatewayID string) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	prefix := gatewayID + "/"
+	for key := range cb.breakers {
+		if strings.HasPrefix(key, prefix) {
+			delete(cb.breakers, key)
+		}
+	}
+}
+
+func breakerKey(gatewayID string, slaveID uint8) string {
+	return fmt.Sprintf("%s/%d", gatewayID, slaveID)
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ThingsPanel/modbus-protocol-plugin/metrics"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// 连接被拒绝的原因
+const (
+	RejectMaxConnections  = "max_connections"            // 总连接数超限
+	RejectMaxPendingPerIP = "max_unauthenticated_per_ip" // 单IP未认证连接数超限
+	RejectRegTimeout      = "registration_timeout"       // 注册包超时未到达
+)
+
+// ConnectionLimiter 连接准入限制（connection_limit配置）
+// 限制总连接数和单IP未认证连接数，防止端口被扫描或恶意连接耗尽资源
+type ConnectionLimiter struct {
+	maxConnections      int           // 最大并发连接数，0不限制
+	maxPendingPerIP     int           // 单IP最大未认证连接数，0不限制
+	registrationTimeout time.Duration // 等待注册包的超时时间
+
+	mutex   sync.Mutex
+	active  int            // 当前连接数
+	pending map[string]int // IP -> 未认证连接数
+
+	rejected    sync.Map // 拒绝原因 -> *int64 累计拒绝次数
+	lastLogTime sync.Map // 拒绝原因 -> time.Time 上次日志时间
+}
+
+// NewConnectionLimiter 创建连接准入限制器
+func NewConnectionLimiter() *ConnectionLimiter {
+	registrationTimeout := viper.GetDuration("connection_limit.registration_timeout")
+	if registrationTimeout <= 0 {
+		registrationTimeout = 30 * time.Second // 默认30秒
+	}
+	cl := &ConnectionLimiter{
+		maxConnections:      viper.GetInt("connection_limit.max_connections"),
+		maxPendingPerIP:     viper.GetInt("connection_limit.max_unauthenticated_per_ip"),
+		registrationTimeout: registrationTimeout,
+		pending:             make(map[string]int),
+	}
+	logrus.Infof("连接准入限制初始化: maxConnections=%d, maxUnauthenticatedPerIP=%d, registrationTimeout=%v",
+		cl.maxConnections, cl.maxPendingPerIP, cl.registrationTimeout)
+	return cl
+}
+
+// RegistrationTimeout 等待注册包的超时时间
+func (cl *ConnectionLimiter) RegistrationTimeout() time.Duration {
+	return cl.registrationTimeout
+}
+
+// Admit 检查是否接受新连接，接受时返回包装后的连接，连接关闭时自动释放名额
+func (cl *ConnectionLimiter) Admit(conn net.Conn, ip string) (net.Conn, bool) {
+	cl.mutex.Lock()
+	reason := ""
+	if cl.maxConnections > 0 && cl.active >= cl.maxConnections {
+		reason = RejectMaxConnections
+	} else if cl.maxPendingPerIP > 0 && cl.pending[ip] >= cl.maxPendingPerIP {
+		reason = RejectMaxPendingPerIP
+	} else {
+		cl.active++
+		cl.pending[ip]++
+	}
+	cl.mutex.Unlock()
+
+	if reason != "" {
+		cl.Reject(reason, ip)
+		return nil, false
+	}
+	return &limitedConn{Conn: conn, limiter: cl, ip: ip}, true
+}
+
+// Authenticated 连接认证成功，不再计入该IP的未认证连接数
+func (cl *ConnectionLimiter) Authenticated(conn net.Conn) {
+	if lc, ok := conn.(*limitedConn); ok {
+		lc.authOnce.Do(func() {
+			cl.mutex.Lock()
+			cl.releasePendingLocked(lc.ip)
+			cl.mutex.Unlock()
+		})
+	}
+}
+
+// Reject 记录一次拒绝，同一原因的日志每分钟最多输出1条
+func (cl *ConnectionLimiter) Reject(reason string, ip string) {
+	v, _ := cl.rejected.LoadOrStore(reason, new(int64))
+	total := atomic.AddInt64(v.(*int64), 1)
+	metrics.RegistrationRejected(reason)
+
+	now := time.Now()
+	if last, ok := cl.lastLogTime.Load(reason); ok && now.Sub(last.(time.Time)) < time.Minute {
+		return
+	}
+	cl.lastLogTime.Store(reason, now)
+	active, pending := cl.Stats()
+	logrus.Warnf("连接已拒绝: reason=%s, ip=%s, 累计拒绝=%d, 当前连接数=%d, 未认证连接数=%d", reason, ip, total, active, pending)
+}
+
+// Stats 当前连接数和未认证连接数
+func (cl *ConnectionLimiter) Stats() (active int, pending int) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	for _, count := range cl.pending {
+		pending += count
+	}
+	return cl.active, pending
+}
+
+// Rejected 各原因的累计拒绝次数
+func (cl *ConnectionLimiter) Rejected() map[string]int64 {
+	result := make(map[string]int64)
+	cl.rejected.Range(func(key, value interface{}) bool {
+		result[key.(string)] = atomic.LoadInt64(value.(*int64))
+		return true
+	})
+	return result
+}
+
+// release 连接关闭时释放名额
+func (cl *ConnectionLimiter) release(lc *limitedConn) {
+	lc.authOnce.Do(func() {
+		cl.mutex.Lock()
+		cl.releasePendingLocked(lc.ip)
+		cl.mutex.Unlock()
+	})
+	cl.mutex.Lock()
+	cl.active--
+	cl.mutex.Unlock()
+}
+
+func (cl *ConnectionLimiter) releasePendingLocked(ip string) {
+	cl.pending[ip]--
+	if cl.pending[ip] <= 0 {
+		delete(cl.pending, ip)
+	}
+}
+
+// limitedConn 计入准入限制的连接，关闭时释放名额
+type limitedConn struct {
+	net.Conn
+	limiter   *ConnectionLimiter
+	ip        string
+	authOnce  sync.Once // 未认证名额只释放一次（认证成功或关闭）
+	closeOnce sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.limiter.release(c)
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"net"
+	"time"
+
+	globaldata "github.com/ThingsPanel/modbus-protocol-plugin/global_data"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// 同一网关重复注册的处理策略（duplicate_registration.policy配置）
+const (
+	DuplicateReplace = "replace" // 关闭旧连接，由新连接接管（默认）
+	DuplicateReject  = "reject"  // 保留旧连接，拒绝新连接
+)
+
+// oldSessionWaitTimeout 替换时等待旧会话采集任务退出的最长时间
+const oldSessionWaitTimeout = 5 * time.Second
+
+func duplicatePolicy() string {
+	if viper.GetString("duplicate_registration.policy") == DuplicateReject {
+		return DuplicateReject
+	}
+	return DuplicateReplace
+}
+
+// registerConnection 登记网关连接，网关已有连接时按策略处理，返回false表示新连接被拒绝
+// 新连接先登记再关闭旧连接，旧连接的断开处理会因连接不一致而跳过，不会清除新连接的数据
+func registerConnection(conn net.Conn, gatewayID string, regPkg string, options *listenerOptions) bool {
+	policy := duplicatePolicy()
+	var old interface{}
+	var loaded bool
+	if policy == DuplicateReject {
+		old, loaded = globaldata.DeviceConnectionMap.LoadOrStore(gatewayID, &conn)
+	} else {
+		old, loaded = globaldata.DeviceConnectionMap.Swap(gatewayID, &conn)
+	}
+	if !loaded {
+		return true
+	}
+	oldConn := *old.(*net.Conn)
+
+	fields := map[string]interface{}{
+		"gateway_id":  gatewayID,
+		"reg_pkg":     regPkg,
+		"policy":      policy,
+		"listener":    options.name,
+		"old_address": oldConn.RemoteAddr().String(),
+		"new_address": conn.RemoteAddr().String(),
+	}
+	if policy == DuplicateReject {
+		auditLog("duplicate_registration_rejected", fields)
+		return false
+	}
+
+	auditLog("duplicate_registration_replaced", fields)
+	replaceConnection(oldConn, gatewayID)
+	return true
+}
+
+// replaceConnection 关闭被替换的旧连接和旧会话，并等待旧会话的采集任务退出，避免新旧任务同时占用总线
+// 网关仍然在线，不发送离线消息
+func replaceConnection(oldConn net.Conn, gatewayID string) {
+	session := closeSession(gatewayID, oldConn)
+	if err := oldConn.Close(); err != nil {
+		logrus.Debugf("关闭旧连接失败: %v", err)
+	}
+	if session == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		session.wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(oldSessionWaitTimeout):
+		logrus.Warnf("等待旧会话采集任务退出超时: gatewayID=%s", gatewayID)
+	}
+}
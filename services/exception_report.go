@@ -2,6 +2,7 @@ package services
 
 import (
 	"encoding/hex"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -47,7 +48,11 @@ func ReportException(err error, tpSubDevice *api.SubDevice, rawRequest []byte, r
 	}
 
 	// 上报异常信息
-	if err := processResponseData(dataMap, tpSubDevice); err != nil {
+	sample := &TelemetrySample{
+		Values:    dataMap,
+		Timestamp: time.Now(),
+	}
+	if err := processResponseData(sample, tpSubDevice); err != nil {
 		logrus.Errorf("Failed to report Modbus exception: %v", err)
 	} else {
 		logrus.Infof("Reported Modbus exception: type=%s, message=%s", getErrorTypeName(modbusErr.Type), modbusErr.Message)
@@ -1,17 +1,17 @@
 package services
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"strings"
-	"sync"
 	"time"
 
+	frametrace "github.com/ThingsPanel/modbus-protocol-plugin/frame_trace"
 	globaldata "github.com/ThingsPanel/modbus-protocol-plugin/global_data"
+	"github.com/ThingsPanel/modbus-protocol-plugin/metrics"
 	"github.com/ThingsPanel/modbus-protocol-plugin/modbus"
-	MQTT "github.com/ThingsPanel/modbus-protocol-plugin/mqtt"
 	tpconfig "github.com/ThingsPanel/modbus-protocol-plugin/tp_config"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -20,65 +20,84 @@ import (
 )
 
 // HandleConn 处理单个连接
-func HandleConn(regPkg, deviceID string) {
+func HandleConn(conn net.Conn, regPkg, deviceID string) *gatewaySession {
 	// 获取网关配置
 	m, _ := globaldata.GateWayConfigMap.Load(deviceID)
 	gatewayConfig := m.(*api.DeviceConfigResponseData)
 
-	// 遍历网关的子设备
-	for _, tpSubDevice := range gatewayConfig.SubDevices {
-		// 存储子设备配置
-		globaldata.SubDeviceConfigMap.Store(tpSubDevice.DeviceID, &tpSubDevice)
-		globaldata.SubDeviceIDAndGateWayIDMap.Store(tpSubDevice.DeviceID, deviceID)
+	// 创建会话并启动采集任务
+	return startSession(conn, regPkg, gatewayConfig)
+}
 
-		// 将tp子设备的表单配置转SubDeviceFormConfig
-		subDeviceFormConfig, err := tpconfig.NewSubDeviceFormConfig(tpSubDevice.ProtocolConfigTemplate, tpSubDevice.SubDeviceAddr)
-		if err != nil {
-			logrus.Error(err.Error())
-			continue
-		}
+// startSubDeviceLoops 启动子设备的所有命令循环，ctx取消时退出，返回各命令循环的运行状态
+func startSubDeviceLoops(ctx context.Context, session *gatewaySession, tpSubDevice *api.SubDevice) []*pollTaskState {
+	// 存储子设备配置
+	globaldata.SubDeviceConfigMap.Store(tpSubDevice.DeviceID, tpSubDevice)
+	globaldata.SubDeviceIDAndGateWayIDMap.Store(tpSubDevice.DeviceID, session.gatewayID)
 
-		// 遍历子设备的表单配置
-		for _, commandRaw := range subDeviceFormConfig.CommandRawList {
-			var endianess modbus.EndianessType
-			if gatewayConfig.ProtocolType == "MODBUS_RTU" {
-				switch commandRaw.Endianess {
-				case "BIG":
-					endianess = modbus.BigEndian
-				case "LITTLE":
-					endianess = modbus.LittleEndian
-				case "BADC":
-					endianess = modbus.ByteSwap
-				case "CDAB":
-					endianess = modbus.WordByteSwap
-				default:
-					endianess = modbus.BigEndian
-				}
-				cmd := modbus.NewRTUCommand(subDeviceFormConfig.SlaveID, commandRaw.FunctionCode, commandRaw.StartingAddress, commandRaw.Quantity, endianess)
-				go handleRTUCommandLoop(&cmd, commandRaw, regPkg, &tpSubDevice, deviceID)
-
-			} else if gatewayConfig.ProtocolType == "MODBUS_TCP" {
-				switch commandRaw.Endianess {
-				case "BIG":
-					endianess = modbus.BigEndian
-				case "LITTLE":
-					endianess = modbus.LittleEndian
-				case "BADC":
-					endianess = modbus.ByteSwap
-				case "CDAB":
-					endianess = modbus.WordByteSwap
-				default:
-					endianess = modbus.BigEndian
-				}
-				cmd := modbus.NewTCPCommand(subDeviceFormConfig.SlaveID, commandRaw.FunctionCode, commandRaw.StartingAddress, commandRaw.Quantity, endianess)
-				go handleTCPCommandLoop(&cmd, commandRaw, regPkg, &tpSubDevice, deviceID)
+	// 将tp子设备的表单配置转SubDeviceFormConfig
+	subDeviceFormConfig, err := tpconfig.NewSubDeviceFormConfig(tpSubDevice.ProtocolConfigTemplate, tpSubDevice.SubDeviceAddr)
+	if err != nil {
+		logrus.Error(err.Error())
+		return nil
+	}
+
+	// 遍历子设备的表单配置
+	var polls []*pollTaskState
+	for _, commandRaw := range subDeviceFormConfig.CommandRawList {
+		poll := newPollTaskState(subDeviceFormConfig.SlaveID, commandRaw)
+		polls = append(polls, poll)
+		var endianess modbus.EndianessType
+		if session.protocolType == "MODBUS_RTU" {
+			switch commandRaw.Endianess {
+			case "BIG":
+				endianess = modbus.BigEndian
+			case "LITTLE":
+				endianess = modbus.LittleEndian
+			case "BADC":
+				endianess = modbus.ByteSwap
+			case "CDAB":
+				endianess = modbus.WordByteSwap
+			default:
+				endianess = modbus.BigEndian
 			}
+			cmd := modbus.NewRTUCommand(subDeviceFormConfig.SlaveID, commandRaw.FunctionCode, commandRaw.StartingAddress, commandRaw.Quantity, endianess)
+			session.wg.Add(1)
+			go func() {
+				defer session.wg.Done()
+				handleRTUCommandLoop(ctx, session, &cmd, commandRaw, tpSubDevice, poll)
+			}()
+
+		} else if session.protocolType == "MODBUS_TCP" {
+			switch commandRaw.Endianess {
+			case "BIG":
+				endianess = modbus.BigEndian
+			case "LITTLE":
+				endianess = modbus.LittleEndian
+			case "BADC":
+				endianess = modbus.ByteSwap
+			case "CDAB":
+				endianess = modbus.WordByteSwap
+			default:
+				endianess = modbus.BigEndian
+			}
+			cmd := modbus.NewTCPCommand(subDeviceFormConfig.SlaveID, commandRaw.FunctionCode, commandRaw.StartingAddress, commandRaw.Quantity, endianess)
+			session.wg.Add(1)
+			go func() {
+				defer session.wg.Done()
+				handleTCPCommandLoop(ctx, session, &cmd, commandRaw, tpSubDevice, poll)
+			}()
 		}
 	}
+	return polls
 }
 
 // handleRTUCommandLoop RTU命令循环处理
-func handleRTUCommandLoop(cmd *modbus.RTUCommand, commandRaw *tpconfig.CommandRaw, regPkg string, subDevice *api.SubDevice, deviceID string) {
+func handleRTUCommandLoop(ctx context.Context, session *gatewaySession, cmd *modbus.RTUCommand, commandRaw *tpconfig.CommandRaw, subDevice *api.SubDevice, poll *pollTaskState) {
+	regPkg := session.regPkg
+	deviceID := session.gatewayID
+	conn := session.conn
+
 	data, err := cmd.Serialize()
 	if err != nil {
 		logrus.Error(err.Error())
@@ -92,41 +111,78 @@ func handleRTUCommandLoop(cmd *modbus.RTUCommand, commandRaw *tpconfig.CommandRa
 
 	logrus.Infof("RTU命令循环启动: deviceID=%s, regPkg=%s, 功能码=0x%02X", deviceID, regPkg, cmd.FunctionCode)
 
+	// 上次成功采集的值
+	var lastValues map[string]interface{}
+
 	for {
-		// 获取连接
-		connVal, exists := globaldata.DeviceConnectionMap.Load(deviceID)
-		if !exists {
-			logrus.Warnf("设备连接已断开，退出: deviceID=%s", deviceID)
+		// 采集任务被停止（连接断开、网关重连、子设备删除或配置变更）
+		if ctx.Err() != nil {
+			logrus.Infof("采集任务已停止: deviceID=%s, subDeviceID=%s", deviceID, subDevice.DeviceID)
 			return
 		}
-		gatewayConn := connVal.(*net.Conn)
-		conn := *gatewayConn
 
-		// 获取设备锁，确保同一设备的命令串行执行
-		if _, ok := globaldata.DeviceRWLock[regPkg]; !ok {
-			globaldata.DeviceRWLock[regPkg] = &sync.Mutex{}
+		// 从站熔断时只按探测间隔采集
+		allow, probe := circuitBreaker.Allow(deviceID, cmd.SlaveAddress)
+		if !allow {
+			sleepContext(ctx, interval)
+			continue
+		}
+		// 探测时不重试，避免长时间占用总线
+		policy := LoadRetryPolicy()
+		if probe {
+			policy.Enabled = false
 		}
-		globaldata.DeviceRWLock[regPkg].Lock()
 
-		// 发送并处理响应
-		err := sendRTUDataAndProcessResponse(conn, data, cmd, commandRaw, regPkg, subDevice)
+		// 获取设备锁，确保同一设备的命令串行执行
+		lock := globaldata.GetDeviceLock(regPkg)
+		lock.Lock()
+		frametrace.SetSubDevice(deviceID, subDevice.DeviceID)
+
+		// 发送并处理响应，超时按重试策略重试
+		// 帧前缀模式可在平台修改网关配置后随时开关
+		framePrefix := globaldata.GetFramePrefix(deviceID)
+		values, err := executeWithRetry(conn, deviceID, policy, func() (map[string]interface{}, error) {
+			return sendRTUDataAndProcessResponse(conn, data, cmd, commandRaw, deviceID, framePrefix, subDevice)
+		})
+
+		lock.Unlock()
+
+		poll.record(values, err)
+		subDeviceStatus.RecordResult(deviceID, subDevice.DeviceID, err)
+		if circuitBreaker.RecordResult(deviceID, cmd.SlaveAddress, err) {
+			// 熔断打开，子设备判定离线
+			subDeviceStatus.MarkOffline(deviceID, subDevice.DeviceID)
+		}
 
-		globaldata.DeviceRWLock[regPkg].Unlock()
+		deadLink := session.recordSlaveResult(cmd.SlaveAddress, err)
 
 		if err != nil {
-			// 任何错误都断开连接，让设备重连
-			logrus.Warnf("RTU错误，断开连接: deviceID=%s, error=%s", deviceID, err.Error())
-			CloseConnection(conn, deviceID)
-			return
+			reportFailureQuality(err, commandRaw, lastValues, subDevice)
+			// 只有连接错误才断开连接，让设备重连
+			if handlePollError(err, conn, deviceID, data, subDevice) {
+				return
+			}
+			// 所有从站都连续超时，连接可能已经半开，断开让设备重连
+			if deadLink {
+				logrus.Warnf("所有从站连续超时，判定连接失效并断开: deviceID=%s", deviceID)
+				CloseConnection(conn, deviceID)
+				return
+			}
+		} else {
+			lastValues = values
 		}
 
 		// 等待间隔时间
-		time.Sleep(interval)
+		sleepContext(ctx, interval)
 	}
 }
 
 // handleTCPCommandLoop TCP命令循环处理
-func handleTCPCommandLoop(cmd *modbus.TCPCommand, commandRaw *tpconfig.CommandRaw, regPkg string, subDevice *api.SubDevice, deviceID string) {
+func handleTCPCommandLoop(ctx context.Context, session *gatewaySession, cmd *modbus.TCPCommand, commandRaw *tpconfig.CommandRaw, subDevice *api.SubDevice, poll *pollTaskState) {
+	regPkg := session.regPkg
+	deviceID := session.gatewayID
+	conn := session.conn
+
 	data, err := cmd.Serialize()
 	if err != nil {
 		logrus.Error(err.Error())
@@ -140,67 +196,158 @@ func handleTCPCommandLoop(cmd *modbus.TCPCommand, commandRaw *tpconfig.CommandRa
 
 	logrus.Infof("TCP命令循环启动: deviceID=%s, regPkg=%s, 功能码=0x%02X", deviceID, regPkg, cmd.FunctionCode)
 
+	// 上次成功采集的值
+	var lastValues map[string]interface{}
+
 	for {
-		// 获取连接
-		connVal, exists := globaldata.DeviceConnectionMap.Load(deviceID)
-		if !exists {
-			logrus.Warnf("设备连接已断开，退出: deviceID=%s", deviceID)
+		// 采集任务被停止（连接断开、网关重连、子设备删除或配置变更）
+		if ctx.Err() != nil {
+			logrus.Infof("采集任务已停止: deviceID=%s, subDeviceID=%s", deviceID, subDevice.DeviceID)
 			return
 		}
-		gatewayConn := connVal.(*net.Conn)
-		conn := *gatewayConn
 
-		// 获取设备锁，确保同一设备的命令串行执行
-		if _, ok := globaldata.DeviceRWLock[regPkg]; !ok {
-			globaldata.DeviceRWLock[regPkg] = &sync.Mutex{}
+		// 从站熔断时只按探测间隔采集
+		allow, probe := circuitBreaker.Allow(deviceID, cmd.SlaveAddress)
+		if !allow {
+			sleepContext(ctx, interval)
+			continue
+		}
+		// 探测时不重试，避免长时间占用总线
+		policy := LoadRetryPolicy()
+		if probe {
+			policy.Enabled = false
 		}
-		globaldata.DeviceRWLock[regPkg].Lock()
 
-		// 发送并处理响应
-		err := sendTCPDataAndProcessResponse(conn, data, cmd, commandRaw, regPkg, subDevice)
+		// 获取设备锁，确保同一设备的命令串行执行
+		lock := globaldata.GetDeviceLock(regPkg)
+		lock.Lock()
+		frametrace.SetSubDevice(deviceID, subDevice.DeviceID)
+
+		// 发送并处理响应，超时按重试策略重试
+		// 帧前缀模式可在平台修改网关配置后随时开关
+		framePrefix := globaldata.GetFramePrefix(deviceID)
+		values, err := executeWithRetry(conn, deviceID, policy, func() (map[string]interface{}, error) {
+			return sendTCPDataAndProcessResponse(conn, data, cmd, commandRaw, deviceID, framePrefix, subDevice)
+		})
+
+		lock.Unlock()
+
+		poll.record(values, err)
+		subDeviceStatus.RecordResult(deviceID, subDevice.DeviceID, err)
+		if circuitBreaker.RecordResult(deviceID, cmd.SlaveAddress, err) {
+			// 熔断打开，子设备判定离线
+			subDeviceStatus.MarkOffline(deviceID, subDevice.DeviceID)
+		}
 
-		globaldata.DeviceRWLock[regPkg].Unlock()
+		deadLink := session.recordSlaveResult(cmd.SlaveAddress, err)
 
 		if err != nil {
-			// 任何错误都断开连接，让设备重连
-			logrus.Warnf("TCP错误，断开连接: deviceID=%s, error=%s", deviceID, err.Error())
-			CloseConnection(conn, deviceID)
-			return
+			reportFailureQuality(err, commandRaw, lastValues, subDevice)
+			// 只有连接错误才断开连接，让设备重连
+			if handlePollError(err, conn, deviceID, data, subDevice) {
+				return
+			}
+			// 所有从站都连续超时，连接可能已经半开，断开让设备重连
+			if deadLink {
+				logrus.Warnf("所有从站连续超时，判定连接失效并断开: deviceID=%s", deviceID)
+				CloseConnection(conn, deviceID)
+				return
+			}
+		} else {
+			lastValues = values
 		}
 
 		// 等待间隔时间
-		time.Sleep(interval)
+		sleepContext(ctx, interval)
+	}
+}
+
+// handlePollError 按错误类型处理采集错误，返回是否已关闭连接
+// 连接错误关闭连接让设备重连；业务错误（Modbus异常响应）已在解析时上报，不断开；超时重试用尽后上报异常，不断开
+// （所有从站都持续超时的半开连接由gatewaySession.recordSlaveResult检测）
+func handlePollError(err error, conn net.Conn, deviceID string, request []byte, subDevice *api.SubDevice) bool {
+	modbusErr := ClassifyError(err)
+	shouldClose, isBusiness := modbusErr.ShouldCloseConnection()
+	if shouldClose {
+		logrus.Warnf("连接错误，断开连接: deviceID=%s, error=%s", deviceID, err.Error())
+		CloseConnection(conn, deviceID)
+		return true
+	}
+	if isBusiness {
+		logrus.Warnf("Modbus异常响应，继续采集: deviceID=%s, subDeviceID=%s, error=%s", deviceID, subDevice.DeviceID, err.Error())
+		return false
 	}
+	if modbusErr.Type == ErrorTypeTimeout {
+		ReportException(modbusErr, subDevice, request, nil)
+	}
+	logrus.Warnf("采集失败，继续采集: deviceID=%s, subDeviceID=%s, type=%s, error=%s", deviceID, subDevice.DeviceID, getErrorTypeName(modbusErr.Type), err.Error())
+	return false
+}
+
+// recordPollMetric 记录一次采集请求的结果
+// 数据已解析时按成功计（上报失败不影响采集结果），否则按错误类型区分
+func recordPollMetric(gatewayID string, subDeviceID string, decoded bool, crcOK bool, err error) {
+	result := metrics.PollOK
+	switch {
+	case decoded && !crcOK:
+		result = metrics.PollCRC
+	case decoded:
+	case err == nil:
+		return
+	default:
+		switch ClassifyError(err).Type {
+		case ErrorTypeTimeout:
+			result = metrics.PollTimeout
+		case ErrorTypeConnection:
+			result = metrics.PollConnection
+		case ErrorTypeBusiness:
+			result = metrics.PollException
+		default:
+			result = metrics.PollDecode
+		}
+	}
+	metrics.RecordPoll(gatewayID, subDeviceID, result)
 }
 
 // sendRTUDataAndProcessResponse 发送RTU数据并处理响应
-func sendRTUDataAndProcessResponse(conn net.Conn, data []byte, cmd *modbus.RTUCommand, commandRaw *tpconfig.CommandRaw, deviceID string, subDevice *api.SubDevice) error {
+func sendRTUDataAndProcessResponse(conn net.Conn, data []byte, cmd *modbus.RTUCommand, commandRaw *tpconfig.CommandRaw, deviceID string, framePrefix []byte, subDevice *api.SubDevice) (values map[string]interface{}, err error) {
+	// 采集结果计入监控指标（含每次重试）
+	decoded := false
+	crcOK := true
+	defer func() {
+		recordPollMetric(deviceID, subDevice.DeviceID, decoded, crcOK, err)
+	}()
+
 	// 清空缓冲区
 	clearBuffer(conn)
 
 	// 写入数据
-	err := conn.SetWriteDeadline(time.Now().Add(3 * time.Second))
+	err = conn.SetWriteDeadline(time.Now().Add(3 * time.Second))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	_, err = conn.Write(data)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	sentAt := time.Now()
 
 	// 读取响应
 	err = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	buf, err := ReadModbusRTUResponse(conn, cmd.FunctionCode)
+	buf, err := ReadModbusRTUResponse(conn, cmd.FunctionCode, framePrefix)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	// 响应帧到达时间作为采集时间
+	receivedAt := time.Now()
+	metrics.ObserveLatency("MODBUS_RTU", receivedAt.Sub(sentAt))
 
 	if len(buf) == 0 {
-		return NewModbusError(ErrorTypeTimeout, 0, "Read timeout", nil)
+		return nil, NewModbusError(ErrorTypeTimeout, 0, "Read timeout", nil)
 	}
 
 	// 检查Modbus异常响应
@@ -210,51 +357,67 @@ func sendRTUDataAndProcessResponse(conn net.Conn, data []byte, cmd *modbus.RTUCo
 		errMsg := fmt.Sprintf("Modbus exception: func=0x%02X, code=0x%02X, %s", functionCode, exceptionCode, desc)
 		err := NewModbusError(ErrorTypeBusiness, exceptionCode, errMsg, nil)
 		ReportException(err, subDevice, data, buf)
-		return err
+		return nil, err
 	}
 
 	// 解析响应
 	respData, err := cmd.ParseAndValidateResponse(buf)
 	if err != nil {
 		ReportException(err, subDevice, data, buf)
-		return err
+		return nil, err
 	}
 
 	// 序列化数据
 	dataMap, err := commandRaw.Serialize(respData)
 	if err != nil {
 		ReportException(err, subDevice, data, buf)
-		return err
+		return nil, err
 	}
 
-	return processResponseData(dataMap, subDevice)
+	decoded = true
+	// CRC不一致时数据仍然上报，只在指标中区分
+	crcOK = modbus.ValidCRC(buf)
+
+	publishTelemetry(NewTelemetrySample(dataMap, receivedAt), subDevice)
+	return dataMap, nil
 }
 
 // sendTCPDataAndProcessResponse 发送TCP数据并处理响应
-func sendTCPDataAndProcessResponse(conn net.Conn, data []byte, cmd *modbus.TCPCommand, commandRaw *tpconfig.CommandRaw, deviceID string, subDevice *api.SubDevice) error {
+func sendTCPDataAndProcessResponse(conn net.Conn, data []byte, cmd *modbus.TCPCommand, commandRaw *tpconfig.CommandRaw, deviceID string, framePrefix []byte, subDevice *api.SubDevice) (values map[string]interface{}, err error) {
+	// 采集结果计入监控指标（含每次重试）
+	decoded := false
+	crcOK := true
+	defer func() {
+		recordPollMetric(deviceID, subDevice.DeviceID, decoded, crcOK, err)
+	}()
+
 	// 清空缓冲区
 	clearBuffer(conn)
 
 	// 写入数据
-	err := conn.SetWriteDeadline(time.Now().Add(3 * time.Second))
+	err = conn.SetWriteDeadline(time.Now().Add(3 * time.Second))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	_, err = conn.Write(data)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	sentAt := time.Now()
 
 	// 读取响应
 	err = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	buf, err := ReadModbusTCPResponse(conn, deviceID)
+	buf, err := ReadModbusTCPResponse(conn, framePrefix)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	// 响应帧到达时间作为采集时间
+	receivedAt := time.Now()
+	metrics.ObserveLatency("MODBUS_TCP", receivedAt.Sub(sentAt))
 
 	// 检查Modbus异常响应
 	isException, exceptionCode, functionCode := modbus.ParseModbusExceptionResponse(buf, "TCP")
@@ -263,24 +426,39 @@ func sendTCPDataAndProcessResponse(conn net.Conn, data []byte, cmd *modbus.TCPCo
 		errMsg := fmt.Sprintf("Modbus exception: func=0x%02X, code=0x%02X, %s", functionCode, exceptionCode, desc)
 		err := NewModbusError(ErrorTypeBusiness, exceptionCode, errMsg, nil)
 		ReportException(err, subDevice, data, buf)
-		return err
+		return nil, err
 	}
 
 	// 解析响应
 	respData, err := cmd.ParseTCPResponse(buf)
 	if err != nil {
 		ReportException(err, subDevice, data, buf)
-		return err
+		return nil, err
 	}
 
 	// 序列化数据
 	dataMap, err := commandRaw.Serialize(respData)
 	if err != nil {
 		ReportException(err, subDevice, data, buf)
-		return err
+		return nil, err
 	}
 
-	return processResponseData(dataMap, subDevice)
+	decoded = true
+
+	publishTelemetry(NewTelemetrySample(dataMap, receivedAt), subDevice)
+	return dataMap, nil
+}
+
+// sleepContext 等待指定时间，ctx取消时提前返回false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
 }
 
 // clearBuffer 清空连接缓冲区
@@ -362,25 +540,3 @@ func flushTimeoutResponse(conn net.Conn) {
 		}
 	}
 }
-
-// processResponseData 处理响应数据并发布到MQTT
-func processResponseData(dataMap map[string]interface{}, tpSubDevice *api.SubDevice) error {
-	payloadMap := map[string]interface{}{
-		"device_id": tpSubDevice.DeviceID,
-		"values":    dataMap,
-	}
-
-	values, err := json.Marshal(payloadMap["values"])
-	if err != nil {
-		return err
-	}
-	logrus.Info("values:", string(values))
-
-	payloadMap["values"] = values
-	payload, err := json.Marshal(payloadMap)
-	if err != nil {
-		return err
-	}
-
-	return MQTT.Publish(string(payload))
-}
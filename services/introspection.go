@@ -0,0 +1,174 @@
+package services
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	globaldata "github.com/ThingsPanel/modbus-protocol-plugin/global_data"
+	tpconfig "github.com/ThingsPanel/modbus-protocol-plugin/tp_config"
+	"github.com/ThingsPanel/tp-protocol-sdk-go/api"
+)
+
+// pollTaskState 一个命令循环的运行状态，供管理接口查询
+type pollTaskState struct {
+	slaveID         uint8
+	functionCode    byte
+	startingAddress uint16
+	quantity        uint16
+	interval        int
+
+	mutex       sync.Mutex
+	lastSuccess time.Time
+	lastError   string
+	lastErrorAt time.Time
+	lastValues  map[string]interface{}
+}
+
+func newPollTaskState(slaveID uint8, commandRaw *tpconfig.CommandRaw) *pollTaskState {
+	return &pollTaskState{
+		slaveID:         slaveID,
+		functionCode:    commandRaw.FunctionCode,
+		startingAddress: commandRaw.StartingAddress,
+		quantity:        commandRaw.Quantity,
+		interval:        commandRaw.Interval,
+	}
+}
+
+// record 记录一次采集结果
+func (p *pollTaskState) record(values map[string]interface{}, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err != nil {
+		p.lastError = err.Error()
+		p.lastErrorAt = time.Now()
+		return
+	}
+	p.lastSuccess = time.Now()
+	p.lastValues = values
+}
+
+// PollTaskInfo 采集任务状态
+type PollTaskInfo struct {
+	SlaveID         uint8                  `json:"slave_id"`
+	FunctionCode    byte                   `json:"function_code"`
+	StartingAddress uint16                 `json:"starting_address"`
+	Quantity        uint16                 `json:"quantity"`
+	Interval        int                    `json:"interval"`
+	Breaker         string                 `json:"breaker"`
+	LastSuccess     *time.Time             `json:"last_success,omitempty"`
+	LastError       string                 `json:"last_error,omitempty"`
+	LastErrorAt     *time.Time             `json:"last_error_at,omitempty"`
+	LastValues      map[string]interface{} `json:"last_values,omitempty"`
+}
+
+// SubDeviceInfo 子设备状态
+type SubDeviceInfo struct {
+	DeviceID      string         `json:"device_id"`
+	SubDeviceAddr string         `json:"sub_device_addr"`
+	Online        *bool          `json:"online,omitempty"` // 尚未判定时为空
+	Tasks         []PollTaskInfo `json:"tasks"`
+}
+
+// GatewayInfo 已连接网关的状态
+type GatewayInfo struct {
+	ID             string          `json:"id"`
+	RemoteIP       string          `json:"remote_ip"`
+	RegPkg         string          `json:"reg_pkg"`
+	ConnectedSince time.Time       `json:"connected_since"`
+	Protocol       string          `json:"protocol"`
+	SubDevices     []SubDeviceInfo `json:"sub_devices,omitempty"`
+}
+
+// ListGateways 所有已连接的网关，不含子设备
+func ListGateways() []GatewayInfo {
+	gateways := make([]GatewayInfo, 0)
+	globaldata.DeviceConnectionMap.Range(func(key, value interface{}) bool {
+		if info, ok := gatewayInfo(key.(string), *value.(*net.Conn), false); ok {
+			gateways = append(gateways, info)
+		}
+		return true
+	})
+	sort.Slice(gateways, func(i, j int) bool { return gateways[i].ID < gateways[j].ID })
+	return gateways
+}
+
+// GetGateway 单个网关的状态，包含子设备和采集任务
+func GetGateway(gatewayID string) (GatewayInfo, bool) {
+	v, ok := globaldata.DeviceConnectionMap.Load(gatewayID)
+	if !ok {
+		return GatewayInfo{}, false
+	}
+	return gatewayInfo(gatewayID, *v.(*net.Conn), true)
+}
+
+func gatewayInfo(gatewayID string, conn net.Conn, withSubDevices bool) (GatewayInfo, bool) {
+	info := GatewayInfo{
+		ID:       gatewayID,
+		RemoteIP: remoteIP(conn),
+	}
+	if v, ok := globaldata.GateWayConfigMap.Load(gatewayID); ok {
+		info.Protocol = v.(*api.DeviceConfigResponseData).ProtocolType
+	}
+
+	var session *gatewaySession
+	if v, ok := gatewaySessionMap.Load(gatewayID); ok {
+		session = v.(*gatewaySession)
+		info.RegPkg = session.regPkg
+		info.ConnectedSince = session.connectedAt
+	}
+	if !withSubDevices {
+		return info, true
+	}
+
+	// 子设备以缓存的子设备配置为准，采集任务状态来自会话
+	info.SubDevices = make([]SubDeviceInfo, 0)
+	globaldata.SubDeviceIDAndGateWayIDMap.Range(func(key, value interface{}) bool {
+		if value.(string) != gatewayID {
+			return true
+		}
+		subDeviceID := key.(string)
+		sub := SubDeviceInfo{DeviceID: subDeviceID, Tasks: make([]PollTaskInfo, 0)}
+		if v, ok := globaldata.SubDeviceConfigMap.Load(subDeviceID); ok {
+			sub.SubDeviceAddr = v.(*api.SubDevice).SubDeviceAddr
+		}
+		if online, reported := subDeviceStatus.Status(subDeviceID); reported {
+			sub.Online = &online
+		}
+		if session != nil {
+			for _, poll := range session.polls(subDeviceID) {
+				sub.Tasks = append(sub.Tasks, poll.info(gatewayID))
+			}
+		}
+		info.SubDevices = append(info.SubDevices, sub)
+		return true
+	})
+	sort.Slice(info.SubDevices, func(i, j int) bool { return info.SubDevices[i].DeviceID < info.SubDevices[j].DeviceID })
+	return info, true
+}
+
+// info 采集任务状态快照
+func (p *pollTaskState) info(gatewayID string) PollTaskInfo {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	info := PollTaskInfo{
+		SlaveID:         p.slaveID,
+		FunctionCode:    p.functionCode,
+		StartingAddress: p.startingAddress,
+		Quantity:        p.quantity,
+		Interval:        p.interval,
+		Breaker:         circuitBreaker.State(gatewayID, p.slaveID),
+		LastError:       p.lastError,
+		LastValues:      p.lastValues,
+	}
+	if !p.lastSuccess.IsZero() {
+		lastSuccess := p.lastSuccess
+		info.LastSuccess = &lastSuccess
+	}
+	if !p.lastErrorAt.IsZero() {
+		lastErrorAt := p.lastErrorAt
+		info.LastErrorAt = &lastErrorAt
+	}
+	return info
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ThingsPanel/modbus-protocol-plugin/metrics"
+	regprofile "github.com/ThingsPanel/modbus-protocol-plugin/reg_profile"
+	serverconfig "github.com/ThingsPanel/modbus-protocol-plugin/server_config"
+	"github.com/sirupsen/logrus"
+)
+
+// listenerOptions 监听器的连接处理选项
+type listenerOptions struct {
+	name         string
+	identifier   string                // 服务标识符
+	protocol     string                // 默认协议类型，平台网关配置未指定时使用
+	profiles     []*regprofile.Profile // 注册包格式，按顺序匹配
+	certIdentity bool                  // 使用客户端证书CN作为注册包，DTU发送的注册包读出丢弃
+	regPkgWait   time.Duration         // 使用客户端证书CN作为注册包时等待DTU注册包的时间
+	outbound     bool                  // 插件主动建立的连接（server.outbound），不做IP访问控制
+}
+
+// acceptedConn 已接受、等待认证的连接
+type acceptedConn struct {
+	conn    net.Conn
+	options *listenerOptions
+}
+
+// 监听器状态
+const (
+	ListenerStarting  = "starting"
+	ListenerListening = "listening"
+	ListenerFailed    = "failed"
+)
+
+// ListenerStatus 监听器运行状态
+type ListenerStatus struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	State   string `json:"state"`
+	Error   string `json:"error,omitempty"`
+}
+
+// 监听器状态列表，按配置顺序
+var (
+	listenerStatusMutex sync.Mutex
+	listenerStatuses    []*ListenerStatus
+)
+
+// setListenerState 更新监听器状态
+func setListenerState(status *ListenerStatus, state string, err error) {
+	listenerStatusMutex.Lock()
+	defer listenerStatusMutex.Unlock()
+	status.State = state
+	if err != nil {
+		status.Error = err.Error()
+	}
+}
+
+// ListenerStatuses 所有监听器的状态，按配置顺序
+func ListenerStatuses() []ListenerStatus {
+	listenerStatusMutex.Lock()
+	defer listenerStatusMutex.Unlock()
+	statuses := make([]ListenerStatus, 0, len(listenerStatuses))
+	for _, status := range listenerStatuses {
+		statuses = append(statuses, *status)
+	}
+	return statuses
+}
+
+// startListeners 按配置启动所有监听器
+func startListeners() {
+	profiles := regprofile.LoadProfiles("registration.profiles")
+	for _, listener := range serverconfig.Listeners() {
+		status := &ListenerStatus{Name: listener.Name, Address: listener.Address, State: ListenerStarting}
+		listenerStatusMutex.Lock()
+		listenerStatuses = append(listenerStatuses, status)
+		listenerStatusMutex.Unlock()
+		options := &listenerOptions{
+			name:       listener.Name,
+			identifier: listener.Identifier,
+			protocol:   listener.Protocol,
+			profiles:   selectProfiles(profiles, listener.RegistrationProfiles),
+		}
+		go startListener(listener, options, status)
+	}
+}
+
+// selectProfiles 按名称选择监听器使用的注册包格式，未指定或名称都无效时使用全部格式
+func selectProfiles(profiles []*regprofile.Profile, names []string) []*regprofile.Profile {
+	if len(names) == 0 {
+		return profiles
+	}
+	byName := make(map[string]*regprofile.Profile, len(profiles))
+	for _, profile := range profiles {
+		byName[profile.Name] = profile
+	}
+	var selected []*regprofile.Profile
+	for _, name := range names {
+		if profile, ok := byName[name]; ok {
+			selected = append(selected, profile)
+		} else {
+			logrus.Errorf("注册包格式不存在，已忽略: %s", name)
+		}
+	}
+	if len(selected) == 0 {
+		return profiles
+	}
+	return selected
+}
+
+// startListener 启动一个监听器
+// 启动失败时只记录日志和状态，健康检查据此判定实例异常
+func startListener(listener serverconfig.Listener, options *listenerOptions, status *ListenerStatus) {
+	var listen net.Listener
+	var err error
+	if listener.TLS.Enabled {
+		var config *tls.Config
+		config, err = loadServerTLSConfig(listener.TLS)
+		if err != nil {
+			logrus.Errorf("监听器 %s TLS配置无效: %v", listener.Name, err)
+			setListenerState(status, ListenerFailed, err)
+			return
+		}
+		options.certIdentity = config.ClientAuth == tls.RequireAndVerifyClientCert && listener.TLS.CertCNAsRegPkg
+		options.regPkgWait = listener.TLS.RegPkgWait
+		if options.regPkgWait <= 0 {
+			options.regPkgWait = 2 * time.Second // 默认2秒
+		}
+		listen, err = tls.Listen("tcp", listener.Address, config)
+	} else {
+		listen, err = net.Listen("tcp", listener.Address)
+	}
+	if err != nil {
+		logrus.Info("Listen() failed, err: ", err)
+		setListenerState(status, ListenerFailed, err)
+		return
+	}
+	setListenerState(status, ListenerListening, nil)
+	logrus.Infof("modbus服务启动成功：%s (监听器=%s, 协议=%s, 标识符=%s, TLS=%v, 证书CN作为注册包=%v)",
+		listener.Address, listener.Name, listener.Protocol, listener.Identifier, listener.TLS.Enabled, options.certIdentity)
+	acceptLoop(listen, options)
+}
+
+// acceptLoop 接受连接，经过IP访问控制和准入限制后交给认证流程
+func acceptLoop(listen net.Listener, options *listenerOptions) {
+	for {
+		conn, err := listen.Accept() // 监听客户端的连接请求
+		if err != nil {
+			logrus.Info("Accept() failed, err: ", err)
+			continue
+		}
+
+		// 检查IP是否被限制
+		clientIP := remoteIP(conn)
+		if allowed, reason := accessControl.Check(clientIP); !allowed {
+			// 限制日志输出频率：每分钟最多1条
+			if accessControl.ShouldLogReject(clientIP) {
+				logrus.Warnf("IP访问受限，连接已拒绝: ip=%s, reason=%s", clientIP, reason)
+			}
+			metrics.AccessBlocked(accessBlockReason(reason))
+			conn.Close()
+			continue
+		}
+
+		// 连接数超限时拒绝
+		admitted, ok := connLimiter.Admit(conn, clientIP)
+		if !ok {
+			conn.Close()
+			continue
+		}
+		conn = admitted
+
+		// 将接受的conn写入管道
+		connChan <- acceptedConn{conn: conn, options: options}
+	}
+}
+
+// accessBlockReason 指标标签只区分拒绝类别，封禁原因不作为标签
+func accessBlockReason(reason string) string {
+	switch reason {
+	case AccessDenyList:
+		return "deny_list"
+	case AccessNotAllowed:
+		return "allow_list"
+	}
+	return "banned"
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	serverconfig "github.com/ThingsPanel/modbus-protocol-plugin/server_config"
+	"github.com/sirupsen/logrus"
+)
+
+// startOutboundClients 按配置主动连接Modbus/TCP服务端，与监听器同时工作
+func startOutboundClients() {
+	for _, target := range serverconfig.OutboundTargets() {
+		tlsConfig, err := loadOutboundTLSConfig(target)
+		if err != nil {
+			logrus.Errorf("主动连接 %s TLS配置无效，不连接: %v", target.Name, err)
+			continue
+		}
+		if target.RegPkg == "" {
+			logrus.Errorf("主动连接 %s 未配置注册包，不连接", target.Name)
+			continue
+		}
+		go runOutbound(target, tlsConfig)
+	}
+}
+
+// loadOutboundTLSConfig 读取主动连接的TLS配置（Modbus/TCP Security），未启用TLS时返回nil
+// Modbus/TCP Security要求TLS1.2及以上并双向认证，必须配置客户端证书
+func loadOutboundTLSConfig(target serverconfig.Outbound) (*tls.Config, error) {
+	if !target.TLS.Enabled {
+		return nil, nil
+	}
+	if target.TLS.CertFile == "" || target.TLS.KeyFile == "" {
+		return nil, errors.New("Modbus/TCP Security要求客户端证书，需配置cert_file和key_file")
+	}
+	cert, err := tls.LoadX509KeyPair(target.TLS.CertFile, target.TLS.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("加载客户端证书失败: %v", err)
+	}
+	config := &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         target.TLS.ServerName,
+		InsecureSkipVerify: target.TLS.InsecureSkipVerify,
+	}
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(target.Address); err == nil {
+			config.ServerName = host
+		}
+	}
+	if caFile := target.TLS.CAFile; caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取CA证书失败: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA证书无效: %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+	if config.InsecureSkipVerify {
+		logrus.Warnf("主动连接 %s 不校验服务端证书，仅用于测试", target.Name)
+	}
+	return config, nil
+}
+
+// runOutbound 连接服务端并采集，连接失败或断开后按重连间隔重新连接
+// 连接后的处理与DTU连接相同：以配置的注册包获取网关配置，之后按网关配置轮询子设备
+func runOutbound(target serverconfig.Outbound, tlsConfig *tls.Config) {
+	options := &listenerOptions{
+		name:     target.Name,
+		protocol: target.Protocol,
+		outbound: true,
+	}
+	for {
+		conn, err := dialOutbound(target, tlsConfig)
+		if err != nil {
+			logrus.Warnf("主动连接 %s (%s) 失败，%v后重试: %v", target.Name, target.Address, target.ReconnectInterval, err)
+		} else {
+			logrus.Infof("主动连接 %s 成功: address=%s, TLS=%v", target.Name, target.Address, tlsConfig != nil)
+			// 服务端不发送注册包和心跳包，注册包即帧前缀，不需要过滤心跳
+			if session := serveGateway(conn, []byte(target.RegPkg), target.RegPkg, nil, options, remoteIP(conn)); session != nil {
+				<-session.ctx.Done()
+				logrus.Infof("主动连接 %s 已断开，%v后重连", target.Name, target.ReconnectInterval)
+			}
+		}
+		time.Sleep(target.ReconnectInterval)
+	}
+}
+
+// dialOutbound 建立连接，启用TLS时在超时时间内完成握手
+func dialOutbound(target serverconfig.Outbound, tlsConfig *tls.Config) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: target.DialTimeout}
+	if tlsConfig == nil {
+		return dialer.Dial("tcp", target.Address)
+	}
+	return tls.DialWithDialer(dialer, "tcp", target.Address, tlsConfig)
+}
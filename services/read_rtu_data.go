@@ -6,39 +6,49 @@ import (
 	"fmt"
 	"net"
 
+	globaldata "github.com/ThingsPanel/modbus-protocol-plugin/global_data"
 	"github.com/sirupsen/logrus"
 )
 
 // ReadModbusRTUResponse 读取Modbus RTU响应
 // expectedFuncCode: 当前请求的功能码，用于验证响应
-func ReadModbusRTUResponse(conn net.Conn, expectedFuncCode byte) ([]byte, error) {
+// framePrefix: 帧前缀模式下每包前的前缀，未开启时为nil
+func ReadModbusRTUResponse(conn net.Conn, expectedFuncCode byte, framePrefix []byte) ([]byte, error) {
+	return readModbusRTUResponse(conn, framePrefix, func(data []byte) []byte {
+		return findModbusResponse(data, expectedFuncCode, len(framePrefix) == 0)
+	})
+}
+
+// readModbusRTUResponse 读取Modbus RTU响应，每次读取后剔除数据块开头的帧前缀，用find在已读取的数据中查找响应
+func readModbusRTUResponse(conn net.Conn, framePrefix []byte, find func(data []byte) []byte) ([]byte, error) {
 	var buffer bytes.Buffer
 	readBuffer := make([]byte, 256)
 
 	// 最多读取两次
 	for i := 0; i < 2; i++ {
 		n, err := conn.Read(readBuffer)
-		logrus.Info("---------读取数据详情(第", i+1, "次)---------")
-		logrus.Info("读取字节数: ", n)
+		logrus.Debug("---------读取数据详情(第", i+1, "次)---------")
+		logrus.Debug("读取字节数: ", n)
 		if n > 0 {
-			logrus.Info("数据内容(hex): ", hex.EncodeToString(readBuffer[:n]))
-			logrus.Info("数据内容(bytes): ", readBuffer[:n])
+			logrus.Debug("数据内容(hex): ", hex.EncodeToString(readBuffer[:n]))
+			logrus.Debug("数据内容(bytes): ", readBuffer[:n])
 		}
 		if err != nil {
-			logrus.Info("读取错误: ", err)
-			logrus.Info("-----------------------------")
+			logrus.Debug("读取错误: ", err)
+			logrus.Debug("-----------------------------")
 			// 超时错误不算连接异常，允许继续处理
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				break
 			}
-			return nil, fmt.Errorf("连接异常: %v", err)
+			return nil, fmt.Errorf("连接异常: %w", err)
 		}
-		logrus.Info("-----------------------------")
+		logrus.Debug("-----------------------------")
 
-		buffer.Write(readBuffer[:n])
+		// 帧前缀模式：DTU可能把一帧拆成多包发送，每包都带前缀
+		buffer.Write(globaldata.TrimFramePrefix(readBuffer[:n], framePrefix))
 
 		// 尝试解析modbus响应，必须匹配功能码
-		if modbusData := findModbusResponse(buffer.Bytes(), expectedFuncCode); modbusData != nil {
+		if modbusData := find(buffer.Bytes()); modbusData != nil {
 			return modbusData, nil
 		}
 	}
@@ -49,42 +59,63 @@ func ReadModbusRTUResponse(conn net.Conn, expectedFuncCode byte) ([]byte, error)
 
 // findModbusResponse 在数据中查找有效的Modbus响应
 // 必须匹配 expectedFuncCode，否则返回nil
-func findModbusResponse(data []byte, expectedFuncCode byte) []byte {
+// skipDigits: 是否跳过0x30-0x39，帧前缀模式下前缀已剔除，不能跳过（从站地址可能在该范围）
+func findModbusResponse(data []byte, expectedFuncCode byte, skipDigits bool) []byte {
 	if len(data) < 5 {
 		return nil
 	}
 
 	for i := 0; i < len(data)-4; i++ {
 		// 跳过0x30-0x39（数字字符干扰）
-		if data[i] >= 0x30 && data[i] <= 0x39 {
-			continue
-		}
-
-		// 检查功能码是否匹配
-		funcCode := data[i+1]
-
-		// 如果不是异常响应，必须匹配请求的功能码
-		if funcCode&0x80 == 0 && funcCode != expectedFuncCode {
+		if skipDigits && data[i] >= 0x30 && data[i] <= 0x39 {
 			continue
 		}
-
-		// 检查功能码是否有效
-		if !isValidFunctionCode(funcCode) {
-			continue
+		if respLen := matchModbusResponse(data[i:], expectedFuncCode); respLen > 0 {
+			return data[i : i+respLen]
 		}
+	}
+	return nil
+}
 
-		respLen, err := calculateResponseLength(data[i:])
-		if err != nil {
+// findSlaveResponse 在数据中查找指定从站的Modbus响应
+// 已知从站地址时只在该地址处匹配，不跳过0x30-0x39，从站地址48-57（即ASCII数字）的响应也能找到
+func findSlaveResponse(data []byte, slaveID byte, expectedFuncCode byte) []byte {
+	for i := 0; i+5 <= len(data); i++ {
+		if data[i] != slaveID {
 			continue
 		}
-
-		if i+respLen <= len(data) {
+		if respLen := matchModbusResponse(data[i:], expectedFuncCode); respLen > 0 {
 			return data[i : i+respLen]
 		}
 	}
 	return nil
 }
 
+// matchModbusResponse 数据开头是否是匹配功能码的完整Modbus响应，返回响应长度，不匹配时返回0
+func matchModbusResponse(data []byte, expectedFuncCode byte) int {
+	if len(data) < 5 {
+		return 0
+	}
+	// 检查功能码是否匹配
+	funcCode := data[1]
+
+	// 如果不是异常响应，必须匹配请求的功能码
+	if funcCode&0x80 == 0 && funcCode != expectedFuncCode {
+		return 0
+	}
+
+	// 检查功能码是否有效
+	if !isValidFunctionCode(funcCode) {
+		return 0
+	}
+
+	respLen, err := calculateResponseLength(data)
+	if err != nil || respLen > len(data) {
+		return 0
+	}
+	return respLen
+}
+
 func isValidFunctionCode(code byte) bool {
 	// 异常响应（最高位为1）也是有效的
 	if code&0x80 != 0 {
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestFindSlaveResponse 按从站地址查找响应，地址为ASCII数字的从站也能找到，前面的数字字符干扰被跳过
+func TestFindSlaveResponse(t *testing.T) {
+	resp := []byte{0x30, 0x03, 0x02, 0x00, 0x2A, 0x00, 0x00}
+	cases := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{"从站地址48", resp, resp},
+		{"数字字符干扰", append([]byte("0123"), resp...), resp},
+		{"其他从站的响应", []byte{0x31, 0x03, 0x02, 0x00, 0x2A, 0x00, 0x00}, nil},
+		{"响应不完整", resp[:5], nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := findSlaveResponse(c.data, 0x30, 0x03); !bytes.Equal(got, c.want) {
+				t.Fatalf("找到% X，期望% X", got, c.want)
+			}
+		})
+	}
+	// 跳过数字字符时找不到地址48的响应
+	if got := findModbusResponse(resp, 0x03, true); got != nil {
+		t.Fatalf("跳过数字字符时找到% X", got)
+	}
+}
+
+// TestFramePrefixInRegisterData 帧前缀模式下只剔除每包开头的前缀，寄存器数据中与前缀相同的字节保留
+func TestFramePrefixInRegisterData(t *testing.T) {
+	prefix := []byte("REG1")
+	// 两个寄存器的值0x5245、0x4731与前缀字节相同
+	resp := []byte{0x01, 0x03, 0x04, 'R', 'E', 'G', '1', 0xA5, 0x5A}
+	conn, dtu := net.Pipe()
+	defer conn.Close()
+	defer dtu.Close()
+	go func() {
+		// DTU把响应拆成两包发送，每包都带前缀
+		dtu.Write(append(append([]byte(nil), prefix...), resp[:8]...))
+		dtu.Write(append(append([]byte(nil), prefix...), resp[8:]...))
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	got, err := ReadModbusRTUResponse(conn, 0x03, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, resp) {
+		t.Fatalf("读到% X，期望% X", got, resp)
+	}
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"math"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// RetryPolicy 超时重试策略（retry_mechanism配置）
+type RetryPolicy struct {
+	Enabled           bool
+	MaxRetries        int           // 最大重试次数
+	RetryInterval     time.Duration // 首次重试间隔
+	BackoffMultiplier float64       // 退避倍数
+}
+
+// LoadRetryPolicy 从配置读取重试策略
+func LoadRetryPolicy() RetryPolicy {
+	policy := RetryPolicy{
+		Enabled:           viper.GetBool("retry_mechanism.enabled"),
+		MaxRetries:        viper.GetInt("retry_mechanism.max_retries"),
+		RetryInterval:     viper.GetDuration("retry_mechanism.retry_interval"),
+		BackoffMultiplier: viper.GetFloat64("retry_mechanism.backoff_multiplier"),
+	}
+	if policy.MaxRetries < 0 {
+		policy.MaxRetries = 0
+	}
+	if policy.RetryInterval <= 0 {
+		policy.RetryInterval = 300 * time.Millisecond
+	}
+	if policy.BackoffMultiplier < 1 {
+		policy.BackoffMultiplier = 1
+	}
+	return policy
+}
+
+// Backoff 第attempt次重试（从0开始）前的等待时间
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	return time.Duration(float64(p.RetryInterval) * math.Pow(p.BackoffMultiplier, float64(attempt)))
+}
+
+// executeWithRetry 执行一次采集，超时错误按重试策略退避重试
+// 每次超时后都排空迟到响应，避免下一次请求读到错位的数据；调用方需持有设备锁
+func executeWithRetry(conn net.Conn, deviceID string, policy RetryPolicy, send func() (map[string]interface{}, error)) (map[string]interface{}, error) {
+	values, err := send()
+	for attempt := 0; err != nil; attempt++ {
+		if !ClassifyError(err).IsRetryable() {
+			return nil, err
+		}
+		flushTimeoutResponse(conn)
+		if !policy.Enabled || attempt >= policy.MaxRetries {
+			return nil, err
+		}
+
+		wait := policy.Backoff(attempt)
+		logrus.Warnf("采集超时，%v后第%d次重试: deviceID=%s, error=%s", wait, attempt+1, deviceID, err.Error())
+		time.Sleep(wait)
+		values, err = send()
+	}
+	return values, nil
+}
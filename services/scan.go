@@ -0,0 +1,544 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	frametrace "github.com/ThingsPanel/modbus-protocol-plugin/frame_trace"
+	globaldata "github.com/ThingsPanel/modbus-protocol-plugin/global_data"
+	"github.com/ThingsPanel/modbus-protocol-plugin/modbus"
+	MQTT "github.com/ThingsPanel/modbus-protocol-plugin/mqtt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// 扫描任务状态
+const (
+	ScanRunning   = "running"
+	ScanDone      = "done"
+	ScanCancelled = "cancelled"
+	ScanFailed    = "failed"
+)
+
+const (
+	maxScanAddresses  = 10000 // 单个地址范围最多扫描的地址数
+	maxScanBlockSize  = 125   // 每次探测最多读取的寄存器数
+	draftMaxQuantity  = 100   // 草稿模板中每条命令最多包含的寄存器（线圈）数
+	draftIntervalSecs = 5     // 草稿模板的采集周期
+)
+
+// ScanRange 扫描的地址范围，包含起止地址
+type ScanRange struct {
+	FunctionCode byte   `json:"function_code"`
+	Start        uint16 `json:"start"`
+	End          uint16 `json:"end"`
+}
+
+// ScanRequest 扫描请求，HTTP接口和MQTT使用相同的格式
+type ScanRequest struct {
+	GatewayID string      `json:"gateway_id"`
+	SlaveFrom int         `json:"slave_from"` // 从站地址范围，默认1~247
+	SlaveTo   int         `json:"slave_to"`
+	SlaveIDs  []int       `json:"slave_ids"`  // 指定时只探测这些从站，忽略slave_from和slave_to
+	Ranges    []ScanRange `json:"ranges"`     // 地址范围，默认保持寄存器0~99
+	TimeoutMs int         `json:"timeout_ms"` // 每次探测的响应超时，默认见scan.probe_timeout
+	BlockSize int         `json:"block_size"` // 每次探测读取的寄存器数，默认见scan.block_size
+}
+
+// ScanSegment 扫描到的一段连续有效地址
+type ScanSegment struct {
+	FunctionCode byte   `json:"function_code"`
+	Start        uint16 `json:"start"`
+	End          uint16 `json:"end"`
+}
+
+// ScanSlave 一个从站的扫描结果
+type ScanSlave struct {
+	SlaveID  uint8                  `json:"slave_id"`
+	Segments []ScanSegment          `json:"segments"`
+	Notes    []string               `json:"notes,omitempty"` // 扫描过程中的异常，如功能码不支持、超时
+	Template map[string]interface{} `json:"template"`        // 草稿子设备模板：{"SlaveID":1,"CommandRawList":[...]}
+}
+
+// ScanResult 扫描任务的状态和结果
+type ScanResult struct {
+	GatewayID    string      `json:"gateway_id"`
+	State        string      `json:"state"`
+	Error        string      `json:"error,omitempty"`
+	StartedAt    time.Time   `json:"started_at"`
+	FinishedAt   *time.Time  `json:"finished_at,omitempty"`
+	Probes       int         `json:"probes"`        // 已发送的探测请求数
+	FoundSlaves  []int       `json:"found_slaves"`  // 已发现的从站
+	CurrentSlave uint8       `json:"current_slave"` // 正在探测的从站
+	Slaves       []ScanSlave `json:"slaves"`        // 已完成地址扫描的从站
+}
+
+// scanJob 一个网关上的扫描任务
+type scanJob struct {
+	session   *gatewaySession
+	request   ScanRequest
+	timeout   time.Duration
+	blockSize int
+	cancel    context.CancelFunc
+
+	mutex  sync.Mutex
+	result ScanResult
+}
+
+// 扫描任务，key是网关ID，value是*scanJob，每个网关只保留最近一次扫描
+var scanJobs sync.Map
+
+// errScanCancelled 扫描被取消
+var errScanCancelled = errors.New("扫描已取消")
+
+// StartScan 在网关总线上开始扫描从站和地址范围，同一网关同时只能有一个扫描任务
+// 每次探测都持有网关的设备锁，与正常采集交替进行
+func StartScan(req ScanRequest) (ScanResult, error) {
+	v, ok := gatewaySessionMap.Load(req.GatewayID)
+	if !ok {
+		return ScanResult{}, fmt.Errorf("网关未连接: %s", req.GatewayID)
+	}
+	session := v.(*gatewaySession)
+	if err := normalizeScanRequest(&req); err != nil {
+		return ScanResult{}, err
+	}
+
+	ctx, cancel := context.WithCancel(session.ctx)
+	job := &scanJob{
+		session:   session,
+		request:   req,
+		timeout:   time.Duration(req.TimeoutMs) * time.Millisecond,
+		blockSize: req.BlockSize,
+		cancel:    cancel,
+		result: ScanResult{
+			GatewayID: req.GatewayID,
+			State:     ScanRunning,
+			StartedAt: time.Now(),
+		},
+	}
+	for {
+		old, loaded := scanJobs.LoadOrStore(req.GatewayID, job)
+		if !loaded {
+			break
+		}
+		if old.(*scanJob).status().State == ScanRunning {
+			cancel()
+			return ScanResult{}, fmt.Errorf("网关正在扫描: %s", req.GatewayID)
+		}
+		if scanJobs.CompareAndSwap(req.GatewayID, old, job) {
+			break
+		}
+	}
+
+	logrus.Infof("开始扫描: gatewayID=%s, 从站=%d个, 地址范围=%d个, 超时=%v", req.GatewayID, len(req.SlaveIDs), len(req.Ranges), job.timeout)
+	go job.run(ctx)
+	return job.status(), nil
+}
+
+// CancelScan 取消网关正在进行的扫描
+func CancelScan(gatewayID string) bool {
+	v, ok := scanJobs.Load(gatewayID)
+	if !ok {
+		return false
+	}
+	job := v.(*scanJob)
+	if job.status().State != ScanRunning {
+		return false
+	}
+	job.cancel()
+	return true
+}
+
+// ScanStatus 查询网关最近一次扫描的状态和结果
+func ScanStatus(gatewayID string) (ScanResult, bool) {
+	v, ok := scanJobs.Load(gatewayID)
+	if !ok {
+		return ScanResult{}, false
+	}
+	return v.(*scanJob).status(), true
+}
+
+// ScanStatuses 查询所有网关最近一次扫描的状态和结果
+func ScanStatuses() []ScanResult {
+	results := []ScanResult{}
+	scanJobs.Range(func(_, v interface{}) bool {
+		results = append(results, v.(*scanJob).status())
+		return true
+	})
+	return results
+}
+
+// HandleScanRequest 处理MQTT下发的扫描请求，启动失败时在结果主题上回复错误
+func HandleScanRequest(payload []byte) {
+	var req ScanRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		logrus.Warnf("扫描请求格式错误: %v", err)
+		return
+	}
+	if _, err := StartScan(req); err != nil {
+		logrus.Warnf("扫描请求失败: gatewayID=%s, err=%v", req.GatewayID, err)
+		publishScanResult(ScanResult{GatewayID: req.GatewayID, State: ScanFailed, Error: err.Error()})
+	}
+}
+
+// normalizeScanRequest 补全默认值并检查参数
+func normalizeScanRequest(req *ScanRequest) error {
+	if len(req.SlaveIDs) == 0 {
+		if req.SlaveFrom == 0 {
+			req.SlaveFrom = 1
+		}
+		if req.SlaveTo == 0 {
+			req.SlaveTo = 247
+		}
+		for id := req.SlaveFrom; id <= req.SlaveTo; id++ {
+			req.SlaveIDs = append(req.SlaveIDs, id)
+		}
+	}
+	if len(req.SlaveIDs) == 0 {
+		return fmt.Errorf("从站地址范围无效: %d~%d", req.SlaveFrom, req.SlaveTo)
+	}
+	for _, id := range req.SlaveIDs {
+		if id < 1 || id > 247 {
+			return fmt.Errorf("从站地址必须在1~247之间: %d", id)
+		}
+	}
+
+	if len(req.Ranges) == 0 {
+		req.Ranges = []ScanRange{{FunctionCode: 0x03, Start: 0, End: 99}}
+	}
+	for _, r := range req.Ranges {
+		if r.FunctionCode < 0x01 || r.FunctionCode > 0x04 {
+			return fmt.Errorf("功能码0x%02X不支持，只能是01/02/03/04", r.FunctionCode)
+		}
+		if r.End < r.Start {
+			return fmt.Errorf("地址范围无效: %d~%d", r.Start, r.End)
+		}
+		if int(r.End-r.Start)+1 > maxScanAddresses {
+			return fmt.Errorf("地址范围%d~%d超过单次扫描上限%d", r.Start, r.End, maxScanAddresses)
+		}
+	}
+
+	if req.TimeoutMs <= 0 {
+		req.TimeoutMs = int(viper.GetDuration("scan.probe_timeout") / time.Millisecond)
+		if req.TimeoutMs <= 0 {
+			req.TimeoutMs = 500
+		}
+	}
+	if req.BlockSize <= 0 {
+		req.BlockSize = viper.GetInt("scan.block_size")
+		if req.BlockSize <= 0 {
+			req.BlockSize = 16
+		}
+	}
+	if req.BlockSize > maxScanBlockSize {
+		req.BlockSize = maxScanBlockSize
+	}
+	return nil
+}
+
+func (j *scanJob) status() ScanResult {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	result := j.result
+	result.FoundSlaves = append([]int{}, j.result.FoundSlaves...)
+	result.Slaves = append([]ScanSlave{}, j.result.Slaves...)
+	return result
+}
+
+func (j *scanJob) update(f func(result *ScanResult)) {
+	j.mutex.Lock()
+	f(&j.result)
+	j.mutex.Unlock()
+}
+
+// run 先探测从站，再对发现的从站逐个扫描地址范围
+func (j *scanJob) run(ctx context.Context) {
+	defer j.cancel()
+	err := j.scan(ctx)
+
+	now := time.Now()
+	j.update(func(result *ScanResult) {
+		result.FinishedAt = &now
+		result.CurrentSlave = 0
+		switch {
+		case err == nil:
+			result.State = ScanDone
+		case errors.Is(err, errScanCancelled):
+			result.State = ScanCancelled
+		default:
+			result.State = ScanFailed
+			result.Error = err.Error()
+		}
+	})
+	result := j.status()
+	logrus.Infof("扫描结束: gatewayID=%s, state=%s, 探测=%d次, 发现从站=%v", result.GatewayID, result.State, result.Probes, result.FoundSlaves)
+	publishScanResult(result)
+}
+
+func (j *scanJob) scan(ctx context.Context) error {
+	// 用第一个地址范围的起始地址探测从站，正常响应和异常响应都说明从站存在
+	first := j.request.Ranges[0]
+	var found []uint8
+	for _, id := range j.request.SlaveIDs {
+		slaveID := uint8(id)
+		j.update(func(result *ScanResult) { result.CurrentSlave = slaveID })
+		resp, err := j.probeWithRetry(ctx, slaveID, first.FunctionCode, first.Start, 1)
+		if err != nil {
+			return err
+		}
+		if !resp.timeout {
+			found = append(found, slaveID)
+			j.update(func(result *ScanResult) { result.FoundSlaves = append(result.FoundSlaves, int(slaveID)) })
+			logrus.Infof("扫描发现从站: gatewayID=%s, slaveID=%d", j.request.GatewayID, slaveID)
+		}
+	}
+
+	for _, slaveID := range found {
+		slave := ScanSlave{SlaveID: slaveID}
+		j.update(func(result *ScanResult) { result.CurrentSlave = slaveID })
+		for _, r := range j.request.Ranges {
+			segments, notes, err := j.scanRange(ctx, slaveID, r)
+			if err != nil {
+				return err
+			}
+			slave.Segments = append(slave.Segments, segments...)
+			slave.Notes = append(slave.Notes, notes...)
+		}
+		slave.Template = draftTemplate(slaveID, slave.Segments)
+		j.update(func(result *ScanResult) { result.Slaves = append(result.Slaves, slave) })
+	}
+	return nil
+}
+
+// scanRange 按块探测地址范围，块返回非法地址异常（0x02）时逐个地址探测找出边界
+func (j *scanJob) scanRange(ctx context.Context, slaveID uint8, r ScanRange) ([]ScanSegment, []string, error) {
+	var segments []ScanSegment
+	var notes []string
+	valid := func(start, end uint16) {
+		if n := len(segments); n > 0 && segments[n-1].End+1 == start {
+			segments[n-1].End = end
+			return
+		}
+		segments = append(segments, ScanSegment{FunctionCode: r.FunctionCode, Start: start, End: end})
+	}
+
+	for addr := int(r.Start); addr <= int(r.End); {
+		quantity := j.blockSize
+		if rest := int(r.End) - addr + 1; quantity > rest {
+			quantity = rest
+		}
+		resp, err := j.probeWithRetry(ctx, slaveID, r.FunctionCode, uint16(addr), uint16(quantity))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch {
+		case resp.timeout:
+			notes = append(notes, fmt.Sprintf("功能码0x%02X 地址%d~%d 无响应", r.FunctionCode, addr, addr+quantity-1))
+		case resp.exception == 0:
+			valid(uint16(addr), uint16(addr+quantity-1))
+		case resp.exception == 0x01:
+			// 从站不支持该功能码，跳过整个范围
+			notes = append(notes, fmt.Sprintf("功能码0x%02X 不支持", r.FunctionCode))
+			return segments, notes, nil
+		case resp.exception == 0x02 && quantity > 1:
+			// 块内有非法地址，逐个探测
+			for single := addr; single < addr+quantity; single++ {
+				resp, err := j.probeWithRetry(ctx, slaveID, r.FunctionCode, uint16(single), 1)
+				if err != nil {
+					return nil, nil, err
+				}
+				if !resp.timeout && resp.exception == 0 {
+					valid(uint16(single), uint16(single))
+				}
+			}
+		case resp.exception == 0x02:
+			// 单个非法地址即为有效范围的边界
+		default:
+			notes = append(notes, fmt.Sprintf("功能码0x%02X 地址%d~%d 异常响应0x%02X: %s",
+				r.FunctionCode, addr, addr+quantity-1, resp.exception, globaldata.GetModbusErrorDesc(resp.exception)))
+		}
+		addr += quantity
+	}
+	return segments, notes, nil
+}
+
+// probeResponse 一次探测的结果
+type probeResponse struct {
+	timeout   bool // 未响应（含地址不匹配的响应）
+	exception byte // 异常码，0表示正常响应
+}
+
+// probeWithRetry 探测一次，未响应时重试一次；返回错误表示扫描需要终止
+func (j *scanJob) probeWithRetry(ctx context.Context, slaveID uint8, functionCode byte, address uint16, quantity uint16) (probeResponse, error) {
+	var resp probeResponse
+	for attempt := 0; attempt < 2; attempt++ {
+		if ctx.Err() != nil {
+			if j.session.ctx.Err() != nil {
+				return resp, errors.New("网关连接已断开")
+			}
+			return resp, errScanCancelled
+		}
+		var err error
+		resp, err = j.probe(slaveID, functionCode, address, quantity)
+		if err != nil {
+			return resp, err
+		}
+		if !resp.timeout {
+			break
+		}
+	}
+	return resp, nil
+}
+
+// probe 持有网关设备锁发送一次读请求并等待响应，只有连接错误才返回error
+func (j *scanJob) probe(slaveID uint8, functionCode byte, address uint16, quantity uint16) (probeResponse, error) {
+	session := j.session
+	conn := session.conn
+	session.mutex.Lock()
+	isTCP := session.protocolType == "MODBUS_TCP"
+	session.mutex.Unlock()
+
+	var request []byte
+	var tcpCmd modbus.TCPCommand
+	var err error
+	if isTCP {
+		tcpCmd = modbus.NewTCPCommand(slaveID, functionCode, address, quantity, modbus.BigEndian)
+		request, err = tcpCmd.Serialize()
+	} else {
+		rtuCmd := modbus.NewRTUCommand(slaveID, functionCode, address, quantity, modbus.BigEndian)
+		request, err = rtuCmd.Serialize()
+	}
+	if err != nil {
+		return probeResponse{}, err
+	}
+
+	lock := globaldata.GetDeviceLock(session.regPkg)
+	lock.Lock()
+	defer lock.Unlock()
+	frametrace.SetSubDevice(session.gatewayID, "")
+	j.update(func(result *ScanResult) { result.Probes++ })
+
+	clearBuffer(conn)
+	if err := conn.SetWriteDeadline(time.Now().Add(3 * time.Second)); err != nil {
+		return probeResponse{}, err
+	}
+	if _, err := conn.Write(request); err != nil {
+		return probeResponse{}, fmt.Errorf("写入失败: %w", err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(j.timeout)); err != nil {
+		return probeResponse{}, err
+	}
+
+	framePrefix := globaldata.GetFramePrefix(session.gatewayID)
+	var buf []byte
+	if isTCP {
+		buf, err = ReadModbusTCPResponse(conn, framePrefix)
+	} else {
+		// 按从站地址查找响应，不跳过0x30-0x39，否则扫描不到地址48-57的从站
+		buf, err = readModbusRTUResponse(conn, framePrefix, func(data []byte) []byte {
+			return findSlaveResponse(data, slaveID, functionCode)
+		})
+	}
+	var netErr net.Error
+	if err != nil && errors.As(err, &netErr) && netErr.Timeout() {
+		err, buf = nil, nil
+	}
+	if err != nil {
+		return probeResponse{}, err
+	}
+	if len(buf) == 0 {
+		return probeResponse{timeout: true}, nil
+	}
+
+	// 转为RTU布局（从站地址、功能码、……）后检查从站地址和功能码，其他从站或迟到的响应视为未响应
+	if isTCP {
+		if buf, err = tcpCmd.ParseTCPResponse(buf); err != nil {
+			return probeResponse{timeout: true}, nil
+		}
+	}
+	if buf[0] != slaveID || buf[1]&0x7F != functionCode {
+		return probeResponse{timeout: true}, nil
+	}
+	if buf[1]&0x80 != 0 {
+		return probeResponse{exception: buf[2]}, nil
+	}
+	return probeResponse{}, nil
+}
+
+// draftTemplate 按扫描到的有效地址生成草稿子设备模板，数据类型统一为uint16（线圈为coil），字节序为BIG
+// 标识符按功能码和地址命名，如HR100、IR0、C5、DI3，使用前需按设备手册修改
+func draftTemplate(slaveID uint8, segments []ScanSegment) map[string]interface{} {
+	commands := []interface{}{}
+	for _, segment := range segments {
+		dataType := "uint16"
+		if segment.FunctionCode == 0x01 || segment.FunctionCode == 0x02 {
+			dataType = "coil"
+		}
+		for start := int(segment.Start); start <= int(segment.End); start += draftMaxQuantity {
+			end := start + draftMaxQuantity - 1
+			if end > int(segment.End) {
+				end = int(segment.End)
+			}
+			ids := make([]string, 0, end-start+1)
+			for addr := start; addr <= end; addr++ {
+				ids = append(ids, fmt.Sprintf("%s%d", identifierPrefix(segment.FunctionCode), addr))
+			}
+			commands = append(commands, map[string]interface{}{
+				"FunctionCode":          segment.FunctionCode,
+				"StartingAddress":       start,
+				"Quantity":              end - start + 1,
+				"Endianess":             "BIG",
+				"Interval":              draftIntervalSecs,
+				"DataType":              dataType,
+				"DataIdentifierListStr": strings.Join(ids, ","),
+				"EquationListStr":       "",
+				"DecimalPlacesListStr":  "",
+			})
+		}
+	}
+	return map[string]interface{}{
+		"SlaveID":        slaveID,
+		"CommandRawList": commands,
+	}
+}
+
+func identifierPrefix(functionCode byte) string {
+	switch functionCode {
+	case 0x01:
+		return "C"
+	case 0x02:
+		return "DI"
+	case 0x04:
+		return "IR"
+	default:
+		return "HR"
+	}
+}
+
+// publishScanResult 扫描结束后发布结果，主题为 {mqtt.scan_result_topic}/{网关ID}
+func publishScanResult(result ScanResult) {
+	// 未配置时使用默认主题，配置为空时不发布
+	prefix := "modbus/scan/result"
+	if viper.IsSet("mqtt.scan_result_topic") {
+		prefix = viper.GetString("mqtt.scan_result_topic")
+	}
+	if prefix == "" || MQTT.MqttClient == nil {
+		return
+	}
+	payload, err := json.Marshal(result)
+	if err != nil {
+		logrus.Warnf("序列化扫描结果失败: %v", err)
+		return
+	}
+	topic := strings.TrimSuffix(prefix, "/") + "/" + result.GatewayID
+	if err := MQTT.MqttClient.Publish(topic, string(payload), uint8(viper.GetUint("mqtt.qos"))); err != nil {
+		logrus.Warnf("发布扫描结果失败: gatewayID=%s, err=%v", result.GatewayID, err)
+	}
+}
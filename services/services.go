@@ -4,76 +4,79 @@ import (
 	"errors"
 	"net"
 	"strings"
-	"sync"
 	"time"
 
 	httpclient "github.com/ThingsPanel/modbus-protocol-plugin/http_client"
 	"github.com/sirupsen/logrus"
 
+	frametrace "github.com/ThingsPanel/modbus-protocol-plugin/frame_trace"
 	globaldata "github.com/ThingsPanel/modbus-protocol-plugin/global_data"
+	"github.com/ThingsPanel/modbus-protocol-plugin/metrics"
 	MQTT "github.com/ThingsPanel/modbus-protocol-plugin/mqtt"
+	regprofile "github.com/ThingsPanel/modbus-protocol-plugin/reg_profile"
 	"github.com/spf13/viper"
 )
 
 // 定义全局的conn管道
-var connChan = make(chan net.Conn)
+var connChan = make(chan acceptedConn)
 
-// 简单的IP限制
-var (
-	blockedIPs = make(map[string]bool)
-	ipMutex    = &sync.Mutex{}
-)
+// 全局IP访问控制
+var accessControl *AccessControl
+
+// 全局子设备状态跟踪器
+var subDeviceStatus *SubDeviceStatusTracker
 
-// 全局认证限流器
-var authLimiter *AuthLimiter
+// 全局从站熔断器
+var circuitBreaker *CircuitBreaker
+
+// 全局连接准入限制
+var connLimiter *ConnectionLimiter
 
 func Start() {
-	// 初始化认证限流器
-	authLimiter = NewAuthLimiter()
+	// 初始化IP访问控制
+	accessControl = NewAccessControl()
+	go accessControl.cleanupLoop(viper.GetDuration(accessControlKey("cleanup_interval", "cleanup_interval")))
+	// 初始化子设备状态跟踪器
+	subDeviceStatus = NewSubDeviceStatusTracker()
+	// 初始化从站熔断器
+	circuitBreaker = NewCircuitBreaker()
+	// 初始化连接准入限制
+	connLimiter = NewConnectionLimiter()
+	// 处理MQTT下发的扫描请求
+	MQTT.SetScanHandler(HandleScanRequest)
+	// MQTT重连后重新上报网关和子设备的当前状态
+	MQTT.SetStatusProvider(currentStatuses)
 	// 启动处理连接的goroutine
 	go handleChanConnections()
-	// 启动服务
-	go startServer()
+	// 启动所有监听器
+	startListeners()
+	// 主动连接配置的Modbus/TCP服务端
+	startOutboundClients()
 }
 
-// startServer启动服务
-func startServer() {
-	serverAddr := viper.GetString("server.address")
-	listen, err := net.Listen("tcp", serverAddr)
-	if err != nil {
-		logrus.Info("Listen() failed, err: ", err)
-		return
-	}
-	logrus.Info("modbus服务启动成功：", serverAddr)
+// handleConnections处理来自管道的连接
+func handleChanConnections() {
 	for {
-		conn, err := listen.Accept() // 监听客户端的连接请求
-		if err != nil {
-			logrus.Info("Accept() failed, err: ", err)
-			continue
-		}
-
-		// 检查IP是否被限制
-		clientIP := strings.Split(conn.RemoteAddr().String(), ":")[0]
-		ipMutex.Lock()
-		blocked := blockedIPs[clientIP]
-		ipMutex.Unlock()
-
-		if blocked {
-			conn.Close()
-			continue
-		}
-
-		// 将接受的conn写入管道
-		connChan <- conn
+		accepted := <-connChan
+		go verifyConnection(accepted.conn, accepted.options) // 处理每个连接的具体逻辑
 	}
 }
 
-// handleConnections处理来自管道的连接
-func handleChanConnections() {
-	for {
-		conn := <-connChan
-		go verifyConnection(conn) // 处理每个连接的具体逻辑
+// currentStatuses 已连接网关（在线）和已判定状态的子设备的当前状态，网关在前
+func currentStatuses() []MQTT.DeviceStatus {
+	var statuses []MQTT.DeviceStatus
+	gatewaySessionMap.Range(func(key, _ interface{}) bool {
+		statuses = append(statuses, MQTT.DeviceStatus{DeviceID: key.(string), Status: "1"})
+		return true
+	})
+	for subDeviceID, online := range subDeviceStatus.ReportedStatuses() {
+		status := "0"
+		if online {
+			status = "1"
+		}
+		statuses = append(statuses, MQTT.DeviceStatus{DeviceID: subDeviceID, Status: status})
 	}
+	return statuses
 }
 
 func CloseConnection(conn net.Conn, regPkg string) {
@@ -89,86 +92,197 @@ func CloseConnection(conn net.Conn, regPkg string) {
 	}
 	logrus.Info("删除全局变量完成：", regPkg)
 	// 做其他事情，比如发送离线消息
-	m := *MQTT.MqttClient
-	err = m.SendStatus(regPkg, "0")
+	err = MQTT.SendStatus(regPkg, "0")
 	if err != nil {
 		logrus.Info("SendStatus() failed, err: ", err)
 	}
+	// 停止该连接上的所有采集任务
+	closeSession(regPkg, conn)
+	// 网关下的子设备同时离线
+	subDeviceStatus.GatewayOffline(regPkg)
+	circuitBreaker.RemoveGateway(regPkg)
+	metrics.RemoveGateway(regPkg)
 	globaldata.GateWayConfigMap.Delete(regPkg)
+	globaldata.GatewayRegPacketMap.Delete(regPkg)
 	globaldata.DeviceConnectionMap.Delete(regPkg)
-	delete(globaldata.DeviceRWLock, regPkg)
 	// 设备离线
 	logrus.Info("设备离线：", regPkg)
 }
 
 // 验证连接并继续处理数据
-func verifyConnection(conn net.Conn) {
-	clientIP := strings.Split(conn.RemoteAddr().String(), ":")[0]
-
-	// 检查IP是否被认证限流
-	if authLimiter.IsBlocked(clientIP) {
-		// 限制日志输出频率：每分钟最多1条
-		if authLimiter.ShouldLogBlock(clientIP) {
-			logrus.Warnf("IP认证被限流，连接已拒绝: %s", clientIP)
-		}
+func verifyConnection(conn net.Conn, options *listenerOptions) {
+	clientIP := remoteIP(conn)
+
+	// TLS连接先完成握手
+	cn, err := tlsHandshake(conn, connLimiter.RegistrationTimeout())
+	if err != nil {
+		logrus.Infof("TLS握手失败: ip=%s, err=%v", clientIP, err)
+		accessControl.RecordFailure(clientIP, "tls handshake failed")
+		metrics.RegistrationRejected("tls_handshake")
 		conn.Close()
 		return
 	}
 
-	var buf [1024]byte
-	n, err := conn.Read(buf[:])
-	if err != nil {
-		// 如果是连接重置错误，将IP加入黑名单
-		if strings.Contains(err.Error(), "connection reset by peer") {
-			ipMutex.Lock()
-			blockedIPs[clientIP] = true
-			ipMutex.Unlock()
-			logrus.Info("IP已被限制: ", clientIP)
-		} else {
-			logrus.Info("Read() failed, err: ", err)
+	var raw []byte
+	var regPkg string
+	var profile *regprofile.Profile
+	var heartbeats [][]byte
+	if options.certIdentity {
+		// 客户端证书已由CA校验，证书CN即网关注册包，DTU仍然发送的注册包读出丢弃
+		regPkg = cn
+		profile = regprofile.DefaultProfiles()[0]
+		var ok bool
+		if raw, heartbeats, ok = readCertRegistration(conn, cn, options.regPkgWait); !ok {
+			conn.Close()
+			return
 		}
-		conn.Close()
-		return
+		logrus.Infof("客户端证书认证：%s", regPkg)
+	} else {
+		var ok bool
+		if raw, ok = readRegistration(conn, clientIP); !ok {
+			conn.Close()
+			return
+		}
+		// 按注册包格式提取网关ID
+		if profile, regPkg, ok = regprofile.Identify(options.profiles, raw); !ok {
+			logrus.Warnf("注册包格式无法识别: ip=%s, data=%X", clientIP, raw)
+			accessControl.RecordFailure(clientIP, "unknown registration format")
+			metrics.RegistrationRejected("unknown_format")
+			conn.Close()
+			return
+		}
+		logrus.Infof("收到客户端发来的注册包：%s (格式=%s)", regPkg, profile.Name)
+		heartbeats = profile.HeartbeatPatterns(raw)
 	}
-	regPkg := string(buf[:n])
-	logrus.Info("收到客户端发来的注册包：", regPkg)
+	serveGateway(conn, raw, regPkg, heartbeats, options, clientIP)
+}
+
+// serveGateway 按注册包向平台获取网关配置，登记连接并开始采集，返回网关会话；失败时关闭连接并返回nil
+// raw为原始注册包（帧前缀模式下作为前缀），heartbeats为需要过滤的心跳包
+func serveGateway(conn net.Conn, raw []byte, regPkg string, heartbeats [][]byte, options *listenerOptions, clientIP string) *gatewaySession {
 	// 首次接收到的是设备regPkg，需要根据regPkg获取设备配置
 	// 凭借voucher
-	voucher := `{"reg_pkg":"` + regPkg + `"}`
-	// 读取设备配置
-	tpGatewayConfig, err := httpclient.GetDeviceConfig(voucher, "")
+	voucher := regPkgVoucher(regPkg)
+	// 读取设备配置，平台不可用时使用本地缓存
+	tpGatewayConfig, fromCache, err := httpclient.GetDeviceConfigWithCache(voucher)
 	if err != nil {
-		// 认证失败，记录限流
-		authLimiter.RecordFailure(clientIP)
+		// 平台不可用不算认证失败，不记录限流
+		if !errors.Is(err, httpclient.ErrPlatformUnavailable) {
+			// 认证失败，记录限流（主动连接不做IP访问控制）
+			if !options.outbound {
+				accessControl.RecordFailure(clientIP, "authentication failed")
+			}
+			metrics.RegistrationRejected("auth_failed")
+		} else {
+			metrics.RegistrationRejected("platform_unavailable")
+		}
 		// 获取设备配置失败，请检查连接包是否正确
 		logrus.Error(err)
 		conn.Close()
-		return
+		return nil
 	}
 
 	// 认证成功，清除限流记录
-	authLimiter.RecordSuccess(clientIP)
+	if !options.outbound {
+		accessControl.RecordSuccess(clientIP)
+	}
+	connLimiter.Authenticated(conn)
+	// 取消注册阶段的读超时
+	conn.SetReadDeadline(time.Time{})
+
+	// 之后的读取都经过心跳过滤，心跳包不会混入Modbus响应
+	// 过滤器下层记录原始收发字节，供按需抓包
+	conn = regprofile.NewHeartbeatFilter(frametrace.WrapConn(conn, tpGatewayConfig.Data.ID), heartbeats)
 
 	logrus.Info("获取设备配置成功：", tpGatewayConfig)
+	// 平台未指定协议类型时使用监听器的默认协议
+	if tpGatewayConfig.Data.ProtocolType == "" {
+		tpGatewayConfig.Data.ProtocolType = options.protocol
+	}
+
+	// 登记网关连接，网关已有连接时按重复注册策略处理
+	if !registerConnection(conn, tpGatewayConfig.Data.ID, regPkg, options) {
+		metrics.RegistrationRejected("duplicate")
+		conn.Close()
+		return nil
+	}
+	metrics.RegistrationAccepted()
 
 	// 将平台网关的配置存入全局变量
 	globaldata.GateWayConfigMap.Store(tpGatewayConfig.Data.ID, &tpGatewayConfig.Data)
+	// 保存原始注册包，帧前缀模式下作为每帧的前缀剔除
+	globaldata.GatewayRegPacketMap.Store(tpGatewayConfig.Data.ID, raw)
 
 	// 设备连接存入全局变量后，清空连接缓冲区（设备重连时可能有上次残留）
 	// 注意：这里不清空注册包，注册包已在上面正常读走了
-	globaldata.DeviceConnectionMap.Store(tpGatewayConfig.Data.ID, &conn)
 	if err := flushConnBuffer(conn); err != nil {
 		logrus.Warnf("清空连接缓冲区失败: %v", err)
 	}
 
-	m := *MQTT.MqttClient
-	err = m.SendStatus(tpGatewayConfig.Data.ID, "1")
+	err = MQTT.SendStatus(tpGatewayConfig.Data.ID, "1")
 	if err != nil {
 		logrus.Info("SendStatus() failed, err: ", err)
 	}
 	// 设备上线
 	logrus.Info("【MQTT上线消息已发送】设备上线(", tpGatewayConfig.Data.ID, "):", regPkg)
-	HandleConn(regPkg, tpGatewayConfig.Data.ID) // 处理连接
+	session := HandleConn(conn, regPkg, tpGatewayConfig.Data.ID) // 处理连接
+	if fromCache {
+		// 配置来自本地缓存，平台恢复后在后台刷新
+		go refreshCachedConfig(voucher, tpGatewayConfig.Data.ID)
+	}
+	return session
+}
+
+// readRegistration 在超时时间内读取注册包，失败时调用方负责关闭连接
+func readRegistration(conn net.Conn, clientIP string) ([]byte, bool) {
+	// 注册包必须在超时时间内到达，避免空闲连接一直占用资源
+	if err := conn.SetReadDeadline(time.Now().Add(connLimiter.RegistrationTimeout())); err != nil {
+		return nil, false
+	}
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			connLimiter.Reject(RejectRegTimeout, clientIP)
+			return nil, false
+		}
+		// 连接重置（常见于端口扫描）按认证失败计数，达到阈值后限时封禁
+		if strings.Contains(err.Error(), "connection reset by peer") {
+			accessControl.RecordFailure(clientIP, "connection reset")
+			logrus.Info("注册前连接被重置: ", clientIP)
+		} else {
+			logrus.Info("Read() failed, err: ", err)
+		}
+		return nil, false
+	}
+	return buf[:n], true
+}
+
+// readCertRegistration 客户端证书认证时读出并丢弃DTU发送的注册包，返回原始注册包和心跳包
+// DTU可能在握手后稍晚才发出注册包，不能依赖清空缓冲区丢弃，否则迟到的注册包会混入第一个Modbus响应；
+// 最多等待wait（监听器tls.reg_pkg_wait），DTU不发送注册包时以证书CN作为原始注册包，不再等待完整的注册超时时间
+// 心跳包同时包括证书CN和DTU实际发送的注册包（默认心跳包与注册包相同，内容可能与CN不同）
+func readCertRegistration(conn net.Conn, cn string, wait time.Duration) ([]byte, [][]byte, bool) {
+	heartbeats := [][]byte{[]byte(cn)}
+	if err := conn.SetReadDeadline(time.Now().Add(wait)); err != nil {
+		return nil, nil, false
+	}
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			logrus.Infof("客户端证书认证的DTU未发送注册包: %s", cn)
+			return []byte(cn), heartbeats, true
+		}
+		logrus.Info("Read() failed, err: ", err)
+		return nil, nil, false
+	}
+	raw := buf[:n]
+	logrus.Infof("丢弃客户端证书认证的DTU发送的注册包: cn=%s, data=%X", cn, raw)
+	if string(raw) != cn {
+		heartbeats = append(heartbeats, raw)
+	}
+	return raw, heartbeats, true
 }
 
 // flushConnBuffer 清空连接缓冲区中的残留数据
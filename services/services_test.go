@@ -0,0 +1,72 @@
+package services
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	regprofile "github.com/ThingsPanel/modbus-protocol-plugin/reg_profile"
+)
+
+// TestCertRegistrationArrivesLate 证书认证的DTU在清空缓冲区之后才发送注册包，注册包被读出丢弃，不混入Modbus响应
+func TestCertRegistrationArrivesLate(t *testing.T) {
+	setupSessionTest(t)
+	conn, dtu := net.Pipe()
+	defer conn.Close()
+	defer dtu.Close()
+
+	regPacket := []byte("REG-DTU-0001")
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		dtu.Write(regPacket)
+	}()
+
+	raw, heartbeats, ok := readCertRegistration(conn, "dtu-cert-cn", 2*time.Second)
+	if !ok {
+		t.Fatal("读取注册包失败")
+	}
+	if !bytes.Equal(raw, regPacket) {
+		t.Fatalf("原始注册包为%q，期望%q", raw, regPacket)
+	}
+	if len(heartbeats) != 2 || string(heartbeats[0]) != "dtu-cert-cn" || !bytes.Equal(heartbeats[1], regPacket) {
+		t.Fatalf("心跳包为%q", heartbeats)
+	}
+
+	// 之后DTU按注册包内容发送心跳，心跳和Modbus响应一起到达
+	filtered := regprofile.NewHeartbeatFilter(conn, heartbeats)
+	resp := []byte{0x01, 0x03, 0x02, 0x00, 0x2A, 0x39, 0x9B}
+	go func() {
+		dtu.Write(regPacket)
+		dtu.Write(resp)
+	}()
+	filtered.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 256)
+	n, err := filtered.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], resp) {
+		t.Fatalf("读到% X，期望% X", buf[:n], resp)
+	}
+}
+
+// TestCertRegistrationMissing 证书认证的DTU不发送注册包时，短暂等待后以证书CN作为注册包，不等待完整的注册超时时间
+func TestCertRegistrationMissing(t *testing.T) {
+	setupSessionTest(t)
+	conn, dtu := net.Pipe()
+	defer conn.Close()
+	defer dtu.Close()
+
+	start := time.Now()
+	raw, heartbeats, ok := readCertRegistration(conn, "dtu-cert-cn", 200*time.Millisecond)
+	if !ok {
+		t.Fatal("未发送注册包时连接被关闭")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("等待注册包%v", elapsed)
+	}
+	if string(raw) != "dtu-cert-cn" || len(heartbeats) != 1 || string(heartbeats[0]) != "dtu-cert-cn" {
+		t.Fatalf("原始注册包为%q，心跳包为%q", raw, heartbeats)
+	}
+}
@@ -0,0 +1,347 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	globaldata "github.com/ThingsPanel/modbus-protocol-plugin/global_data"
+	httpclient "github.com/ThingsPanel/modbus-protocol-plugin/http_client"
+	"github.com/ThingsPanel/tp-protocol-sdk-go/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// 网关会话map, key是网关ID，value是*gatewaySession
+var gatewaySessionMap sync.Map
+
+// subDeviceTask 子设备采集任务
+type subDeviceTask struct {
+	fingerprint string             // 子设备配置指纹，用于判断配置是否变化
+	cancel      context.CancelFunc // 停止该子设备的所有命令循环
+	polls       []*pollTaskState   // 各命令循环的运行状态
+}
+
+// gatewaySession 一次网关连接会话
+// 会话持有连接和根context，连接上的所有采集任务都从根context派生，断开或被新连接替换时统一取消
+type gatewaySession struct {
+	gatewayID    string
+	regPkg       string
+	conn         net.Conn
+	protocolType string
+	connectedAt  time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup // 会话下所有命令循环
+
+	mutex         sync.Mutex
+	subDevices    map[string]*subDeviceTask // 子设备ID -> 采集任务
+	slaveTimeouts map[uint8]int             // 从站地址 -> 连续超时次数，用于检测半开连接
+}
+
+func newGatewaySession(conn net.Conn, regPkg string, gatewayID string, protocolType string) *gatewaySession {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &gatewaySession{
+		gatewayID:     gatewayID,
+		regPkg:        regPkg,
+		conn:          conn,
+		protocolType:  protocolType,
+		connectedAt:   time.Now(),
+		ctx:           ctx,
+		cancel:        cancel,
+		subDevices:    make(map[string]*subDeviceTask),
+		slaveTimeouts: make(map[uint8]int),
+	}
+}
+
+// start 启动子设备的采集任务
+func (s *gatewaySession) start(tpSubDevice *api.SubDevice) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.startLocked(tpSubDevice)
+}
+
+// startLocked 启动子设备的采集任务，已存在的任务先停止，调用方需持有锁
+func (s *gatewaySession) startLocked(tpSubDevice *api.SubDevice) {
+	if s.ctx.Err() != nil {
+		return
+	}
+	if old, ok := s.subDevices[tpSubDevice.DeviceID]; ok {
+		old.cancel()
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.subDevices[tpSubDevice.DeviceID] = &subDeviceTask{
+		fingerprint: subDeviceFingerprint(tpSubDevice),
+		cancel:      cancel,
+		polls:       startSubDeviceLoops(ctx, s, tpSubDevice),
+	}
+}
+
+// polls 子设备各命令循环的运行状态
+func (s *gatewaySession) polls(subDeviceID string) []*pollTaskState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if task, ok := s.subDevices[subDeviceID]; ok {
+		return task.polls
+	}
+	return nil
+}
+
+// stopLocked 停止子设备的采集任务并清除其配置，调用方需持有锁
+func (s *gatewaySession) stopLocked(subDeviceID string) {
+	if task, ok := s.subDevices[subDeviceID]; ok {
+		task.cancel()
+		delete(s.subDevices, subDeviceID)
+	}
+	// 子设备可能已经被新会话接管，只清除属于本网关的缓存
+	if gatewayID, ok := globaldata.SubDeviceIDAndGateWayIDMap.Load(subDeviceID); ok && gatewayID == s.gatewayID {
+		if _, replaced := s.replaced(); !replaced {
+			globaldata.SubDeviceConfigMap.Delete(subDeviceID)
+			globaldata.SubDeviceIDAndGateWayIDMap.Delete(subDeviceID)
+		}
+	}
+}
+
+// recordSlaveResult 记录从站的采集结果，返回连接是否已判定为半开
+// 只有超时计入连续超时次数，从站或DTU有任何应答即清零；连接错误由调用方直接断开，不计入
+// 会话下所有采集中的从站都连续超时达到link_check.timeout_threshold时判定为半开：
+// DTU断电或网络中断时写入可能长时间不报错，网关会一直显示在线并占用注册
+func (s *gatewaySession) recordSlaveResult(slaveID uint8, err error) bool {
+	var errType ErrorType = -1
+	if err != nil {
+		errType = ClassifyError(err).Type
+	}
+	if errType == ErrorTypeConnection {
+		return false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if errType != ErrorTypeTimeout {
+		s.slaveTimeouts[slaveID] = 0
+		return false
+	}
+	s.slaveTimeouts[slaveID]++
+
+	threshold := linkCheckThreshold()
+	if threshold <= 0 {
+		return false
+	}
+	slaves := 0
+	for _, task := range s.subDevices {
+		for _, poll := range task.polls {
+			if s.slaveTimeouts[poll.slaveID] < threshold {
+				return false
+			}
+			slaves++
+		}
+	}
+	return slaves > 0
+}
+
+// linkCheckThreshold 半开连接检测的连续超时阈值，未启用时返回0
+func linkCheckThreshold() int {
+	if !viper.GetBool("link_check.enabled") {
+		return 0
+	}
+	threshold := viper.GetInt("link_check.timeout_threshold")
+	if threshold <= 0 {
+		threshold = 5 // 默认每个从站连续超时5次
+	}
+	return threshold
+}
+
+// replaced 返回当前登记的会话，以及本会话是否已被新会话替换
+func (s *gatewaySession) replaced() (*gatewaySession, bool) {
+	v, ok := gatewaySessionMap.Load(s.gatewayID)
+	if !ok {
+		return nil, false
+	}
+	current := v.(*gatewaySession)
+	return current, current != s
+}
+
+// reload 对比新旧配置，只重启新增或变化的子设备任务，停止已删除的子设备任务
+func (s *gatewaySession) reload(gatewayConfig *api.DeviceConfigResponseData) (started int, stopped int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// 协议类型变化时所有子设备都需要重启
+	protocolChanged := s.protocolType != gatewayConfig.ProtocolType
+	s.protocolType = gatewayConfig.ProtocolType
+
+	newSubDevices := make(map[string]*api.SubDevice, len(gatewayConfig.SubDevices))
+	for i := range gatewayConfig.SubDevices {
+		newSubDevices[gatewayConfig.SubDevices[i].DeviceID] = &gatewayConfig.SubDevices[i]
+	}
+
+	for subDeviceID := range s.subDevices {
+		if _, ok := newSubDevices[subDeviceID]; !ok {
+			s.stopLocked(subDeviceID)
+			logrus.Infof("子设备已删除，停止采集: gatewayID=%s, subDeviceID=%s", s.gatewayID, subDeviceID)
+			stopped++
+		}
+	}
+
+	for subDeviceID, tpSubDevice := range newSubDevices {
+		task, ok := s.subDevices[subDeviceID]
+		if ok && !protocolChanged && task.fingerprint == subDeviceFingerprint(tpSubDevice) {
+			// 配置未变化，继续使用原任务，只更新缓存的子设备配置
+			globaldata.SubDeviceConfigMap.Store(subDeviceID, tpSubDevice)
+			continue
+		}
+		s.startLocked(tpSubDevice)
+		logrus.Infof("子设备配置变化，重启采集: gatewayID=%s, subDeviceID=%s", s.gatewayID, subDeviceID)
+		started++
+	}
+	return started, stopped
+}
+
+// close 取消会话下的所有采集任务，不等待退出
+func (s *gatewaySession) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cancel()
+	for subDeviceID := range s.subDevices {
+		s.stopLocked(subDeviceID)
+	}
+}
+
+// wait 等待会话下的所有命令循环退出
+func (s *gatewaySession) wait() {
+	s.wg.Wait()
+}
+
+// subDeviceFingerprint 计算子设备配置指纹
+func subDeviceFingerprint(tpSubDevice *api.SubDevice) string {
+	data, err := json.Marshal(struct {
+		SubDeviceAddr          string                 `json:"sub_device_addr"`
+		ProtocolConfigTemplate map[string]interface{} `json:"protocol_config_template"`
+	}{tpSubDevice.SubDeviceAddr, tpSubDevice.ProtocolConfigTemplate})
+	if err != nil {
+		// 无法计算指纹时视为每次都变化
+		return fmt.Sprintf("%p-%d", tpSubDevice, time.Now().UnixNano())
+	}
+	return string(data)
+}
+
+// startSession 为新连接创建会话并启动采集任务，同一网关的旧会话会被取消
+func startSession(conn net.Conn, regPkg string, gatewayConfig *api.DeviceConfigResponseData) *gatewaySession {
+	session := newGatewaySession(conn, regPkg, gatewayConfig.ID, gatewayConfig.ProtocolType)
+	if old, loaded := gatewaySessionMap.Swap(gatewayConfig.ID, session); loaded {
+		logrus.Infof("网关重连，取消旧会话的采集任务: gatewayID=%s", gatewayConfig.ID)
+		old.(*gatewaySession).close()
+	}
+
+	// 遍历网关的子设备，为每个子设备启动采集任务
+	for i := range gatewayConfig.SubDevices {
+		session.start(&gatewayConfig.SubDevices[i])
+	}
+	return session
+}
+
+// closeSession 连接断开时取消对应会话并返回该会话；连接已被新会话替换时不做处理，返回nil
+func closeSession(gatewayID string, conn net.Conn) *gatewaySession {
+	v, ok := gatewaySessionMap.Load(gatewayID)
+	if !ok {
+		return nil
+	}
+	session := v.(*gatewaySession)
+	if session.conn != conn {
+		return nil
+	}
+	session.close()
+	gatewaySessionMap.CompareAndDelete(gatewayID, session)
+	return session
+}
+
+// ConnectedGatewayIDs 当前已连接（有采集会话）的网关ID
+func ConnectedGatewayIDs() []string {
+	var ids []string
+	gatewaySessionMap.Range(func(key, value interface{}) bool {
+		ids = append(ids, key.(string))
+		return true
+	})
+	sort.Strings(ids)
+	return ids
+}
+
+// GatewayVoucher 已连接网关向平台获取配置使用的凭证（与注册时相同，本地配置缓存按凭证保存）
+func GatewayVoucher(gatewayID string) (string, bool) {
+	v, ok := gatewaySessionMap.Load(gatewayID)
+	if !ok {
+		return "", false
+	}
+	return regPkgVoucher(v.(*gatewaySession).regPkg), true
+}
+
+// regPkgVoucher 由注册包生成获取网关配置的凭证
+func regPkgVoucher(regPkg string) string {
+	return `{"reg_pkg":"` + regPkg + `"}`
+}
+
+// ReloadGatewayConfig 平台修改网关或子设备后应用新配置，不断开网关连接
+func ReloadGatewayConfig(gatewayConfig *api.DeviceConfigResponseData) error {
+	v, ok := gatewaySessionMap.Load(gatewayConfig.ID)
+	if !ok {
+		return fmt.Errorf("网关未连接: %s", gatewayConfig.ID)
+	}
+	session := v.(*gatewaySession)
+
+	// 平台未指定协议类型时沿用当前协议（监听器的默认协议）
+	if gatewayConfig.ProtocolType == "" {
+		session.mutex.Lock()
+		gatewayConfig.ProtocolType = session.protocolType
+		session.mutex.Unlock()
+	}
+
+	// 更换网关配置
+	globaldata.GateWayConfigMap.Store(gatewayConfig.ID, gatewayConfig)
+	started, stopped := session.reload(gatewayConfig)
+	logrus.Infof("网关配置已更新: gatewayID=%s, 重启子设备=%d, 停止子设备=%d", gatewayConfig.ID, started, stopped)
+	return nil
+}
+
+// refreshCachedConfig 网关使用缓存配置上线后，定期向平台获取最新配置，成功后应用并结束
+// 会话结束（断开或被新连接替换）时退出
+func refreshCachedConfig(voucher string, gatewayID string) {
+	v, ok := gatewaySessionMap.Load(gatewayID)
+	if !ok {
+		return
+	}
+	session := v.(*gatewaySession)
+
+	interval := viper.GetDuration("config_cache.refresh_interval")
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	for sleepContext(session.ctx, interval) {
+		response, fromCache, err := httpclient.GetDeviceConfigWithCache(voucher)
+		if err != nil && !errors.Is(err, httpclient.ErrPlatformUnavailable) {
+			logrus.Warnf("平台拒绝了缓存配置对应的注册包，断开连接: gatewayID=%s, err=%v", gatewayID, err)
+			CloseConnection(session.conn, gatewayID)
+			return
+		}
+		if err != nil || fromCache {
+			logrus.Debugf("平台仍不可用，稍后重新获取网关配置: gatewayID=%s", gatewayID)
+			continue
+		}
+		if response.Data.ID != gatewayID {
+			logrus.Warnf("平台返回的网关ID与缓存不一致，断开连接重新认证: cached=%s, platform=%s", gatewayID, response.Data.ID)
+			CloseConnection(session.conn, gatewayID)
+			return
+		}
+		if err := ReloadGatewayConfig(&response.Data); err != nil {
+			logrus.Warnf("应用平台最新配置失败: %v", err)
+			return
+		}
+		logrus.Infof("平台已恢复，网关配置已刷新: gatewayID=%s", gatewayID)
+		return
+	}
+}
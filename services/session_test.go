@@ -0,0 +1,260 @@
+package services
+
+import (
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	MQTT "github.com/ThingsPanel/modbus-protocol-plugin/mqtt"
+	"github.com/ThingsPanel/tp-protocol-sdk-go/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// setupSessionTest 初始化采集任务依赖的全局对象，MQTT客户端不连接代理，发布直接失败
+func setupSessionTest(t *testing.T) {
+	t.Helper()
+	logrus.SetOutput(io.Discard)
+	MQTT.MqttClient = MQTT.NewClient("tcp://127.0.0.1:1", "", "", nil, nil)
+	subDeviceStatus = NewSubDeviceStatusTracker()
+	circuitBreaker = NewCircuitBreaker()
+}
+
+// testGatewayConfig 一个RTU网关，带一个读保持寄存器的子设备
+func testGatewayConfig(gatewayID string) *api.DeviceConfigResponseData {
+	return &api.DeviceConfigResponseData{
+		ID:           gatewayID,
+		ProtocolType: "MODBUS_RTU",
+		SubDevices: []api.SubDevice{{
+			DeviceID:      gatewayID + "-sub",
+			SubDeviceAddr: "1",
+			ProtocolConfigTemplate: map[string]interface{}{
+				"SlaveID": float64(1),
+				"CommandRawList": []interface{}{map[string]interface{}{
+					"FunctionCode":          float64(3),
+					"StartingAddress":       float64(0),
+					"Quantity":              float64(1),
+					"Endianess":             "BIG",
+					"Interval":              float64(1),
+					"DataType":              "int16",
+					"DataIdentifierListStr": "A1",
+				}},
+			},
+		}},
+	}
+}
+
+// fakeRTUSlave 模拟从站，每收到一个请求回复一个寄存器的值，连接关闭后退出
+func fakeRTUSlave(conn net.Conn, done chan<- struct{}) {
+	defer close(done)
+	buf := make([]byte, 256)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		if n < 2 {
+			continue
+		}
+		if _, err := conn.Write([]byte{buf[0], 0x03, 0x02, 0x00, 0x2A, 0x00, 0x00}); err != nil {
+			return
+		}
+	}
+}
+
+// silentSlave 模拟半开连接：读取请求但从不回复，连接关闭后退出
+func silentSlave(conn net.Conn, done chan<- struct{}) {
+	defer close(done)
+	buf := make([]byte, 256)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
+// setLinkCheck 开启半开连接检测，测试结束后恢复
+func setLinkCheck(t *testing.T, threshold int) {
+	t.Helper()
+	viper.Set("link_check.enabled", true)
+	viper.Set("link_check.timeout_threshold", threshold)
+	t.Cleanup(func() {
+		viper.Set("link_check.enabled", nil)
+		viper.Set("link_check.timeout_threshold", nil)
+	})
+}
+
+// waitGoroutines 等待goroutine数量回落到基线
+func waitGoroutines(t *testing.T, baseline int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if runtime.NumGoroutine() <= baseline {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	buf := make([]byte, 1<<16)
+	n := runtime.Stack(buf, true)
+	t.Fatalf("goroutine泄漏: 基线=%d, 当前=%d\n%s", baseline, runtime.NumGoroutine(), buf[:n])
+}
+
+func TestSessionReconnectDoesNotLeakGoroutines(t *testing.T) {
+	setupSessionTest(t)
+	baseline := runtime.NumGoroutine()
+
+	const gatewayID = "gw-reconnect"
+	var (
+		sessions []*gatewaySession
+		pipes    []net.Conn
+		slaves   []chan struct{}
+	)
+	for i := 0; i < 5; i++ {
+		server, client := net.Pipe()
+		done := make(chan struct{})
+		go fakeRTUSlave(client, done)
+		pipes = append(pipes, server, client)
+		slaves = append(slaves, done)
+
+		// 快速重连：新会话替换旧会话，旧会话的采集任务必须全部退出
+		sessions = append(sessions, startSession(server, "reg-"+gatewayID, testGatewayConfig(gatewayID)))
+		time.Sleep(150 * time.Millisecond)
+		if i > 0 {
+			waitSession(t, sessions[i-1])
+		}
+	}
+
+	last := sessions[len(sessions)-1]
+	if last.ctx.Err() != nil {
+		t.Fatal("当前会话不应被取消")
+	}
+	closeSession(gatewayID, last.conn)
+	waitSession(t, last)
+	if _, ok := gatewaySessionMap.Load(gatewayID); ok {
+		t.Fatal("断开后会话应被移除")
+	}
+
+	for _, conn := range pipes {
+		conn.Close()
+	}
+	for _, done := range slaves {
+		<-done
+	}
+	waitGoroutines(t, baseline)
+}
+
+func TestSessionReloadStopsRemovedSubDevices(t *testing.T) {
+	setupSessionTest(t)
+	baseline := runtime.NumGoroutine()
+
+	const gatewayID = "gw-reload"
+	server, client := net.Pipe()
+	done := make(chan struct{})
+	go fakeRTUSlave(client, done)
+
+	config := testGatewayConfig(gatewayID)
+	session := startSession(server, "reg-"+gatewayID, config)
+	subDeviceID := config.SubDevices[0].DeviceID
+
+	// 配置未变化时不重启
+	if started, stopped := session.reload(testGatewayConfig(gatewayID)); started != 0 || stopped != 0 {
+		t.Fatalf("配置未变化: started=%d, stopped=%d", started, stopped)
+	}
+
+	// 删除子设备后其采集任务退出，会话本身保持
+	empty := testGatewayConfig(gatewayID)
+	empty.SubDevices = nil
+	if started, stopped := session.reload(empty); started != 0 || stopped != 1 {
+		t.Fatalf("删除子设备: started=%d, stopped=%d", started, stopped)
+	}
+	waitSession(t, session)
+	if session.ctx.Err() != nil {
+		t.Fatal("重新加载配置不应取消会话")
+	}
+	if _, ok := session.subDevices[subDeviceID]; ok {
+		t.Fatal("已删除子设备的任务应被移除")
+	}
+
+	closeSession(gatewayID, server)
+	server.Close()
+	client.Close()
+	<-done
+	waitGoroutines(t, baseline)
+}
+
+// waitSession 等待会话下的命令循环全部退出
+func waitSession(t *testing.T, session *gatewaySession) {
+	t.Helper()
+	exited := make(chan struct{})
+	go func() {
+		session.wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("会话 %s 的采集任务未退出", session.gatewayID)
+	}
+}
+
+// TestRecordSlaveResult 只有所有从站都连续超时达到阈值才判定半开，任何从站应答都不判定
+func TestRecordSlaveResult(t *testing.T) {
+	setLinkCheck(t, 2)
+	session := newGatewaySession(nil, "reg-link", "gw-link", "MODBUS_RTU")
+	session.subDevices["sub1"] = &subDeviceTask{polls: []*pollTaskState{{slaveID: 1}}}
+	session.subDevices["sub2"] = &subDeviceTask{polls: []*pollTaskState{{slaveID: 2}, {slaveID: 2}}}
+	timeout := NewModbusError(ErrorTypeTimeout, 0, "Read timeout", nil)
+	exception := NewModbusError(ErrorTypeBusiness, 0x02, "Modbus exception", nil)
+
+	steps := []struct {
+		slaveID uint8
+		err     error
+		dead    bool
+	}{
+		{1, timeout, false},
+		{2, timeout, false},
+		{1, timeout, false},
+		{2, exception, false}, // 从站2有应答，重新计数
+		{2, timeout, false},
+		{1, timeout, false},
+		{2, timeout, true},
+		{1, nil, false},
+	}
+	for i, step := range steps {
+		if dead := session.recordSlaveResult(step.slaveID, step.err); dead != step.dead {
+			t.Fatalf("第%d步: 判定半开=%v，期望%v", i+1, dead, step.dead)
+		}
+	}
+
+	// 关闭检测后不判定
+	viper.Set("link_check.enabled", false)
+	for i := 0; i < 5; i++ {
+		if session.recordSlaveResult(1, timeout) || session.recordSlaveResult(2, timeout) {
+			t.Fatal("关闭检测后不应判定半开")
+		}
+	}
+}
+
+// TestHalfOpenConnectionClosed 从站全部不应答时断开连接，让DTU重连
+func TestHalfOpenConnectionClosed(t *testing.T) {
+	setupSessionTest(t)
+	setLinkCheck(t, 1)
+
+	const gatewayID = "gw-half-open"
+	server, client := net.Pipe()
+	done := make(chan struct{})
+	go silentSlave(client, done)
+
+	session := startSession(server, "reg-"+gatewayID, testGatewayConfig(gatewayID))
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("从站全部超时后连接未断开")
+	}
+
+	closeSession(gatewayID, server)
+	waitSession(t, session)
+	client.Close()
+}
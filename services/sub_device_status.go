@@ -0,0 +1,208 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	MQTT "github.com/ThingsPanel/modbus-protocol-plugin/mqtt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// subDeviceState 单个子设备的通讯状态
+type subDeviceState struct {
+	gatewayID  string
+	online     bool
+	reported   bool      // 是否已经上报过状态
+	successes  int       // 连续成功次数
+	failures   int       // 连续失败次数
+	lastChange time.Time // 上次上报状态变化的时间
+}
+
+// SubDeviceStatusTracker 子设备在线离线状态跟踪
+// 连续失败达到阈值判定离线，连续成功达到阈值判定在线；状态变化后至少保持minHold，抑制抖动
+type SubDeviceStatusTracker struct {
+	states           map[string]*subDeviceState // 子设备ID -> 状态
+	mutex            sync.Mutex
+	enabled          bool
+	offlineThreshold int
+	onlineThreshold  int
+	minHold          time.Duration
+}
+
+// NewSubDeviceStatusTracker 创建子设备状态跟踪器
+func NewSubDeviceStatusTracker() *SubDeviceStatusTracker {
+	enabled := viper.GetBool("sub_device_status.enabled")
+
+	offlineThreshold := viper.GetInt("sub_device_status.offline_threshold")
+	if offlineThreshold <= 0 {
+		offlineThreshold = 3 // 默认连续失败3次
+	}
+
+	onlineThreshold := viper.GetInt("sub_device_status.online_threshold")
+	if onlineThreshold <= 0 {
+		onlineThreshold = 1 // 默认成功1次
+	}
+
+	minHold := viper.GetDuration("sub_device_status.min_hold")
+	if minHold < 0 {
+		minHold = 0
+	}
+
+	logrus.Infof("子设备状态跟踪初始化: enabled=%v, offlineThreshold=%d, onlineThreshold=%d, minHold=%v", enabled, offlineThreshold, onlineThreshold, minHold)
+
+	return &SubDeviceStatusTracker{
+		states:           make(map[string]*subDeviceState),
+		enabled:          enabled,
+		offlineThreshold: offlineThreshold,
+		onlineThreshold:  onlineThreshold,
+		minHold:          minHold,
+	}
+}
+
+// RecordResult 记录一次采集结果，err为nil或设备有应答（异常响应、解析错误）都视为通讯成功
+func (t *SubDeviceStatusTracker) RecordResult(gatewayID string, subDeviceID string, err error) {
+	if !t.enabled {
+		return
+	}
+	t.record(gatewayID, subDeviceID, !isCommFailure(err))
+}
+
+// MarkOffline 强制将子设备标记为离线（如熔断打开），不受阈值限制
+func (t *SubDeviceStatusTracker) MarkOffline(gatewayID string, subDeviceID string) {
+	if !t.enabled {
+		return
+	}
+	t.mutex.Lock()
+	state := t.getState(gatewayID, subDeviceID)
+	state.successes = 0
+	state.failures = t.offlineThreshold
+	changed := t.setOnline(state, false, true)
+	t.mutex.Unlock()
+
+	if changed {
+		publishSubDeviceStatus(subDeviceID, false)
+	}
+}
+
+// GatewayOffline 网关离线时，将其下所有已上报在线的子设备置为离线并清除状态
+func (t *SubDeviceStatusTracker) GatewayOffline(gatewayID string) {
+	if !t.enabled {
+		return
+	}
+	var offline []string
+	t.mutex.Lock()
+	for subDeviceID, state := range t.states {
+		if state.gatewayID != gatewayID {
+			continue
+		}
+		if state.reported && state.online {
+			offline = append(offline, subDeviceID)
+		}
+		delete(t.states, subDeviceID)
+	}
+	t.mutex.Unlock()
+
+	for _, subDeviceID := range offline {
+		publishSubDeviceStatus(subDeviceID, false)
+	}
+}
+
+// Status 获取子设备当前状态，reported为false表示尚未判定
+func (t *SubDeviceStatusTracker) Status(subDeviceID string) (online bool, reported bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if state, ok := t.states[subDeviceID]; ok {
+		return state.online, state.reported
+	}
+	return false, false
+}
+
+// ReportedStatuses 所有已上报过状态的子设备的当前状态，子设备ID -> 是否在线
+func (t *SubDeviceStatusTracker) ReportedStatuses() map[string]bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	statuses := make(map[string]bool)
+	for subDeviceID, state := range t.states {
+		if state.reported {
+			statuses[subDeviceID] = state.online
+		}
+	}
+	return statuses
+}
+
+func (t *SubDeviceStatusTracker) record(gatewayID string, subDeviceID string, success bool) {
+	t.mutex.Lock()
+	state := t.getState(gatewayID, subDeviceID)
+	changed := false
+	if success {
+		state.successes++
+		state.failures = 0
+		if state.successes >= t.onlineThreshold {
+			changed = t.setOnline(state, true, false)
+		}
+	} else {
+		state.failures++
+		state.successes = 0
+		if state.failures >= t.offlineThreshold {
+			changed = t.setOnline(state, false, false)
+		}
+	}
+	online := state.online
+	t.mutex.Unlock()
+
+	if changed {
+		publishSubDeviceStatus(subDeviceID, online)
+	}
+}
+
+// getState 获取子设备状态，不存在则创建，调用方需持有锁
+func (t *SubDeviceStatusTracker) getState(gatewayID string, subDeviceID string) *subDeviceState {
+	state, ok := t.states[subDeviceID]
+	if !ok {
+		state = &subDeviceState{gatewayID: gatewayID}
+		t.states[subDeviceID] = state
+	}
+	state.gatewayID = gatewayID
+	return state
+}
+
+// setOnline 切换状态，返回是否需要上报；未到最短保持时间的变化暂不生效，调用方需持有锁
+func (t *SubDeviceStatusTracker) setOnline(state *subDeviceState, online bool, force bool) bool {
+	if state.reported && state.online == online {
+		return false
+	}
+	if state.reported && !force && time.Since(state.lastChange) < t.minHold {
+		return false
+	}
+	state.online = online
+	state.reported = true
+	state.lastChange = time.Now()
+	return true
+}
+
+// isCommFailure 是否为通讯失败（超时或连接错误）
+func isCommFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch ClassifyError(err).Type {
+	case ErrorTypeConnection, ErrorTypeTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// publishSubDeviceStatus 上报子设备在线离线状态
+func publishSubDeviceStatus(subDeviceID string, online bool) {
+	status := "0"
+	if online {
+		status = "1"
+	}
+	if err := MQTT.SendStatus(subDeviceID, status); err != nil {
+		logrus.Warnf("子设备状态上报失败: subDeviceID=%s, err=%v", subDeviceID, err)
+		return
+	}
+	logrus.Infof("子设备状态变化: subDeviceID=%s, online=%v", subDeviceID, online)
+}
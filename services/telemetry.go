@@ -0,0 +1,124 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	MQTT "github.com/ThingsPanel/modbus-protocol-plugin/mqtt"
+	tpconfig "github.com/ThingsPanel/modbus-protocol-plugin/tp_config"
+	"github.com/ThingsPanel/tp-protocol-sdk-go/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// 数据质量标识
+const (
+	QualityGood        = "good"         // 采集成功
+	QualityStale       = "stale"        // 本次采集失败，沿用上次成功采集的值
+	QualityCommFailure = "comm_failure" // 通讯失败（超时、连接异常）
+	QualityDecodeError = "decode_error" // 响应无法解析（异常响应、长度错误、公式错误等）
+)
+
+// TelemetrySample 一次采集的结果
+type TelemetrySample struct {
+	Values    map[string]interface{} // 数据标识符 -> 值
+	Quality   map[string]string      // 数据标识符 -> 数据质量，为nil时不上报
+	Timestamp time.Time              // 采集时间（响应帧到达的时间）
+}
+
+// NewTelemetrySample 创建一次成功采集的结果
+func NewTelemetrySample(values map[string]interface{}, ts time.Time) *TelemetrySample {
+	sample := &TelemetrySample{
+		Values:    values,
+		Timestamp: ts,
+	}
+	if qualityEnabled() {
+		sample.Quality = make(map[string]string, len(values))
+		for key := range values {
+			sample.Quality[key] = QualityGood
+		}
+	}
+	return sample
+}
+
+// qualityEnabled 是否在遥测中附带数据质量
+func qualityEnabled() bool {
+	return viper.GetBool("telemetry.quality.enabled")
+}
+
+// errorQuality 根据错误类型得到数据质量
+func errorQuality(err error) string {
+	if isCommFailure(err) {
+		return QualityCommFailure
+	}
+	return QualityDecodeError
+}
+
+// reportFailureQuality 采集失败时上报该命令下所有数据点的质量
+// lastValues 为上次成功采集的值，开启 hold_last_value 时以 stale 质量重新上报
+func reportFailureQuality(err error, commandRaw *tpconfig.CommandRaw, lastValues map[string]interface{}, tpSubDevice *api.SubDevice) {
+	if !qualityEnabled() {
+		return
+	}
+
+	holdLastValue := viper.GetBool("telemetry.quality.hold_last_value") && len(lastValues) > 0
+	quality := errorQuality(err)
+
+	sample := &TelemetrySample{
+		Values:    make(map[string]interface{}),
+		Quality:   make(map[string]string),
+		Timestamp: time.Now(),
+	}
+	for _, id := range strings.Split(commandRaw.DataIdetifierListStr, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if holdLastValue {
+			if val, ok := lastValues[id]; ok {
+				sample.Values[id] = val
+				sample.Quality[id] = QualityStale
+				continue
+			}
+		}
+		sample.Quality[id] = quality
+	}
+
+	if err := processResponseData(sample, tpSubDevice); err != nil {
+		logrus.Errorf("上报数据质量失败: %v", err)
+	}
+}
+
+// publishTelemetry 上报一次成功采集的结果
+// 上报失败（离线队列未启用时MQTT断开等）只记录日志，不影响采集结果、数据质量和子设备状态
+func publishTelemetry(sample *TelemetrySample, tpSubDevice *api.SubDevice) {
+	if err := processResponseData(sample, tpSubDevice); err != nil {
+		logrus.Errorf("上报遥测失败: subDeviceID=%s, error=%v", tpSubDevice.DeviceID, err)
+	}
+}
+
+// processResponseData 处理响应数据并发布到MQTT
+func processResponseData(sample *TelemetrySample, tpSubDevice *api.SubDevice) error {
+	values, err := json.Marshal(sample.Values)
+	if err != nil {
+		return err
+	}
+	logrus.Info("values:", string(values))
+
+	payloadMap := map[string]interface{}{
+		"device_id": tpSubDevice.DeviceID,
+		"values":    values,
+		"ts":        sample.Timestamp.UnixMilli(),
+	}
+	if sample.Quality != nil {
+		payloadMap["quality"] = sample.Quality
+	}
+
+	payload, err := json.Marshal(payloadMap)
+	if err != nil {
+		return err
+	}
+
+	return MQTT.Publish(string(payload))
+}
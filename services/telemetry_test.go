@@ -0,0 +1,46 @@
+package services
+
+import (
+	"net"
+	"testing"
+
+	"github.com/ThingsPanel/modbus-protocol-plugin/modbus"
+	tpconfig "github.com/ThingsPanel/modbus-protocol-plugin/tp_config"
+	"github.com/ThingsPanel/tp-protocol-sdk-go/api"
+)
+
+// TestPublishFailureIsNotPollError 采集成功但MQTT发布失败时，采集结果仍然是成功
+func TestPublishFailureIsNotPollError(t *testing.T) {
+	setupSessionTest(t)
+	conn, slaveConn := net.Pipe()
+	done := make(chan struct{})
+	go fakeRTUSlave(slaveConn, done)
+	defer func() {
+		conn.Close()
+		slaveConn.Close()
+		<-done
+	}()
+
+	cmd := modbus.NewRTUCommand(1, 0x03, 0, 1, modbus.BigEndian)
+	data, err := cmd.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commandRaw := &tpconfig.CommandRaw{
+		FunctionCode:         0x03,
+		Quantity:             1,
+		Endianess:            "BIG",
+		Interval:             1,
+		DataType:             "int16",
+		DataIdetifierListStr: "A1",
+	}
+	subDevice := &api.SubDevice{DeviceID: "publish-fail-sub"}
+
+	values, err := sendRTUDataAndProcessResponse(conn, data, &cmd, commandRaw, "publish-fail-gw", nil, subDevice)
+	if err != nil {
+		t.Fatalf("发布失败被当作采集错误: %v", err)
+	}
+	if values["A1"] != float64(42) {
+		t.Fatalf("采集值为%v，期望42", values)
+	}
+}
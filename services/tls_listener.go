@@ -0,0 +1,72 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	serverconfig "github.com/ThingsPanel/modbus-protocol-plugin/server_config"
+)
+
+// loadServerTLSConfig 读取监听器的TLS配置
+// 配置client_ca_file时要求DTU提供由该CA签发的客户端证书
+func loadServerTLSConfig(tlsConfig serverconfig.TLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("加载服务端证书失败: %v", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if caFile := tlsConfig.ClientCAFile; caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取客户端CA证书失败: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("客户端CA证书无效: %s", caFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// tlsHandshake 在超时时间内完成TLS握手，返回客户端证书的CN（没有客户端证书时为空）
+// 非TLS连接直接返回
+func tlsHandshake(conn net.Conn, timeout time.Duration) (string, error) {
+	if lc, ok := conn.(*limitedConn); ok {
+		conn = lc.Conn
+	}
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+
+	if err := tlsConn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return "", err
+	}
+	if err := tlsConn.SetDeadline(time.Time{}); err != nil {
+		return "", err
+	}
+
+	state := tlsConn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return "", nil
+	}
+	cn := state.PeerCertificates[0].Subject.CommonName
+	if cn == "" {
+		return "", errors.New("客户端证书缺少CN")
+	}
+	return cn, nil
+}
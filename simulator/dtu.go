@@ -0,0 +1,316 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	regprofile "github.com/ThingsPanel/modbus-protocol-plugin/reg_profile"
+	"github.com/sirupsen/logrus"
+)
+
+// Faults 故障注入，各比例为0~1之间的概率，按每个请求独立判定
+type Faults struct {
+	Delay         time.Duration `mapstructure:"delay"`          // 响应前固定延迟
+	Jitter        time.Duration `mapstructure:"jitter"`         // 在固定延迟上增加0~jitter的随机延迟
+	SilenceRate   float64       `mapstructure:"silence_rate"`   // 不响应
+	ExceptionRate float64       `mapstructure:"exception_rate"` // 返回异常响应
+	ExceptionCode byte          `mapstructure:"exception_code"` // 注入的异常码，默认0x04
+	CRCErrorRate  float64       `mapstructure:"crc_error_rate"` // RTU响应CRC错误
+	FragmentRate  float64       `mapstructure:"fragment_rate"`  // 响应拆成两包发送
+	FragmentDelay time.Duration `mapstructure:"fragment_delay"` // 两包之间的间隔，默认50ms
+	StrayRate     float64       `mapstructure:"stray_rate"`     // 响应前插入杂散字节
+	StrayBytes    string        `mapstructure:"stray_bytes"`    // 杂散字节，"hex:"开头表示十六进制，默认 hex:00
+}
+
+// DTUConfig 模拟DTU配置
+type DTUConfig struct {
+	Address           string        `mapstructure:"address"`            // 插件的监听地址
+	Framing           string        `mapstructure:"framing"`            // rtu 或 tcp
+	Registration      string        `mapstructure:"registration"`       // 注册包，"hex:"开头表示十六进制
+	Heartbeat         string        `mapstructure:"heartbeat"`          // 心跳包，为空不发送
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat_interval"` // 心跳间隔，默认30s
+	PrefixedFrames    bool          `mapstructure:"prefixed_frames"`    // 每个响应前附加注册包
+	ReconnectInterval time.Duration `mapstructure:"reconnect_interval"` // 断开后重连间隔，默认5s
+	Slaves            []SlaveConfig `mapstructure:"slaves"`
+	Faults            Faults        `mapstructure:"faults"`
+}
+
+// Stats DTU收发统计
+type Stats struct {
+	Connects  int64 // 成功连接次数
+	Requests  int64 // 收到的请求数
+	Responses int64 // 发送的响应数（含异常响应）
+	Faults    int64 // 注入的故障数
+}
+
+// DTU 模拟的DTU：连接插件、发送注册包和心跳包，并作为总线上的从站响应请求
+type DTU struct {
+	config       DTUConfig
+	registration []byte
+	heartbeat    []byte
+	stray        []byte
+	slaves       map[uint8]*Slave
+
+	randMutex sync.Mutex
+	rand      *rand.Rand
+
+	writeMutex sync.Mutex // 心跳和响应共用连接
+
+	connects  int64
+	requests  int64
+	responses int64
+	faults    int64
+}
+
+// NewDTU 按配置创建模拟DTU
+func NewDTU(config DTUConfig) (*DTU, error) {
+	if config.Framing == "" {
+		config.Framing = FramingRTU
+	}
+	if config.Framing != FramingRTU && config.Framing != FramingTCP {
+		return nil, fmt.Errorf("不支持的帧格式: %s", config.Framing)
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = 30 * time.Second
+	}
+	if config.ReconnectInterval <= 0 {
+		config.ReconnectInterval = 5 * time.Second
+	}
+	if config.Faults.ExceptionCode == 0 {
+		config.Faults.ExceptionCode = ExceptionDeviceFailure
+	}
+	if config.Faults.FragmentDelay <= 0 {
+		config.Faults.FragmentDelay = 50 * time.Millisecond
+	}
+	if config.Faults.StrayBytes == "" {
+		config.Faults.StrayBytes = "hex:00"
+	}
+
+	d := &DTU{
+		config: config,
+		slaves: make(map[uint8]*Slave),
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	var err error
+	if d.registration, err = regprofile.ParseBytes(config.Registration); err != nil {
+		return nil, fmt.Errorf("注册包无效: %v", err)
+	}
+	if len(d.registration) == 0 {
+		return nil, fmt.Errorf("注册包不能为空")
+	}
+	if d.heartbeat, err = regprofile.ParseBytes(config.Heartbeat); err != nil {
+		return nil, fmt.Errorf("心跳包无效: %v", err)
+	}
+	if d.stray, err = regprofile.ParseBytes(config.Faults.StrayBytes); err != nil {
+		return nil, fmt.Errorf("杂散字节无效: %v", err)
+	}
+	for _, slaveConfig := range config.Slaves {
+		d.slaves[slaveConfig.ID] = NewSlave(slaveConfig)
+	}
+	return d, nil
+}
+
+// Name DTU注册包，用于日志
+func (d *DTU) Name() string {
+	return d.config.Registration
+}
+
+// Slave 按从站地址获取从站
+func (d *DTU) Slave(id uint8) *Slave {
+	return d.slaves[id]
+}
+
+// Stats 收发统计
+func (d *DTU) Stats() Stats {
+	return Stats{
+		Connects:  atomic.LoadInt64(&d.connects),
+		Requests:  atomic.LoadInt64(&d.requests),
+		Responses: atomic.LoadInt64(&d.responses),
+		Faults:    atomic.LoadInt64(&d.faults),
+	}
+}
+
+// Run 连接插件并处理请求，连接断开后按间隔重连，ctx取消时返回
+func (d *DTU) Run(ctx context.Context) {
+	for {
+		if err := d.serve(ctx); err != nil && ctx.Err() == nil {
+			logrus.Warnf("[%s] 连接断开: %v", d.Name(), err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.config.ReconnectInterval):
+		}
+	}
+}
+
+// serve 建立一次连接并处理请求直到连接断开
+func (d *DTU) serve(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", d.config.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// ctx取消时关闭连接，结束阻塞的读取
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := d.write(conn, d.registration); err != nil {
+		return err
+	}
+	atomic.AddInt64(&d.connects, 1)
+	logrus.Infof("[%s] 已连接 %s，帧格式=%s", d.Name(), d.config.Address, d.config.Framing)
+
+	done := make(chan struct{})
+	defer close(done)
+	if len(d.heartbeat) > 0 {
+		go d.heartbeatLoop(conn, done)
+	}
+	return d.handleRequests(conn)
+}
+
+// ServeConn 作为Modbus服务端（如PLC）处理插件主动建立的连接，不发送注册包和心跳包，连接断开或ctx取消时返回
+func (d *DTU) ServeConn(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	atomic.AddInt64(&d.connects, 1)
+	logrus.Infof("[%s] 已接受连接 %s，帧格式=%s", d.Name(), conn.RemoteAddr(), d.config.Framing)
+	return d.handleRequests(conn)
+}
+
+// handleRequests 读取并响应请求直到连接断开
+func (d *DTU) handleRequests(conn net.Conn) error {
+	next := nextRTURequest
+	if d.config.Framing == FramingTCP {
+		next = nextTCPRequest
+	}
+	var pending []byte
+	buf := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		pending = append(pending, buf[:n]...)
+		for {
+			req, rest, ok := next(pending)
+			pending = rest
+			if !ok {
+				break
+			}
+			atomic.AddInt64(&d.requests, 1)
+			if err := d.respond(conn, req); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (d *DTU) heartbeatLoop(conn net.Conn, done chan struct{}) {
+	ticker := time.NewTicker(d.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := d.write(conn, d.heartbeat); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (d *DTU) write(conn net.Conn, data []byte) error {
+	d.writeMutex.Lock()
+	defer d.writeMutex.Unlock()
+	_, err := conn.Write(data)
+	return err
+}
+
+// chance 按概率判定是否注入故障
+func (d *DTU) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	d.randMutex.Lock()
+	defer d.randMutex.Unlock()
+	return d.rand.Float64() < rate
+}
+
+func (d *DTU) jitter() time.Duration {
+	if d.config.Faults.Jitter <= 0 {
+		return 0
+	}
+	d.randMutex.Lock()
+	defer d.randMutex.Unlock()
+	return time.Duration(d.rand.Int63n(int64(d.config.Faults.Jitter)))
+}
+
+// respond 按从站和故障配置响应一个请求
+func (d *DTU) respond(conn net.Conn, req request) error {
+	slave, ok := d.slaves[req.unitID]
+	if !ok {
+		// 总线上没有该从站，不响应
+		return nil
+	}
+	faults := d.config.Faults
+	if d.chance(faults.SilenceRate) {
+		atomic.AddInt64(&d.faults, 1)
+		logrus.Debugf("[%s] 注入故障: 不响应 slave=%d", d.Name(), req.unitID)
+		return nil
+	}
+
+	pdu := slave.Handle(req.pdu)
+	if d.chance(faults.ExceptionRate) {
+		atomic.AddInt64(&d.faults, 1)
+		pdu = exception(req.pdu[0], faults.ExceptionCode)
+	}
+
+	var frame []byte
+	if d.config.Framing == FramingTCP {
+		frame = encodeTCP(req.transactionID, req.unitID, pdu)
+	} else {
+		frame = encodeRTU(req.unitID, pdu)
+		if d.chance(faults.CRCErrorRate) {
+			atomic.AddInt64(&d.faults, 1)
+			frame[len(frame)-1] ^= 0xFF
+		}
+	}
+
+	if delay := faults.Delay + d.jitter(); delay > 0 {
+		time.Sleep(delay)
+	}
+
+	if d.chance(faults.StrayRate) {
+		atomic.AddInt64(&d.faults, 1)
+		frame = append(append([]byte(nil), d.stray...), frame...)
+	}
+	if d.config.PrefixedFrames {
+		frame = append(append([]byte(nil), d.registration...), frame...)
+	}
+
+	atomic.AddInt64(&d.responses, 1)
+	if len(frame) > 1 && d.chance(faults.FragmentRate) {
+		atomic.AddInt64(&d.faults, 1)
+		half := len(frame) / 2
+		if err := d.write(conn, frame[:half]); err != nil {
+			return err
+		}
+		time.Sleep(faults.FragmentDelay)
+		second := frame[half:]
+		if d.config.PrefixedFrames {
+			// 帧前缀模式下DTU每包都带前缀
+			second = append(append([]byte(nil), d.registration...), second...)
+		}
+		return d.write(conn, second)
+	}
+	return d.write(conn, frame)
+}
@@ -0,0 +1,100 @@
+package simulator
+
+import "encoding/binary"
+
+// 帧格式
+const (
+	FramingRTU = "rtu"
+	FramingTCP = "tcp"
+)
+
+// crc16 Modbus RTU校验值（独立实现，不依赖被测的modbus包）
+func crc16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&0x0001 != 0 {
+				crc = (crc >> 1) ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// request 一个完整的请求帧
+type request struct {
+	unitID        uint8
+	pdu           []byte
+	transactionID uint16 // 仅Modbus TCP
+}
+
+// nextRTURequest 从缓冲区中取出下一个RTU请求，数据不完整时返回ok=false
+// 校验失败或功能码无法识别时丢弃一个字节重新同步，返回丢弃后的缓冲区
+func nextRTURequest(buf []byte) (req request, rest []byte, ok bool) {
+	for len(buf) >= 2 {
+		var size int
+		switch buf[1] {
+		case 0x01, 0x02, 0x03, 0x04, 0x05, 0x06:
+			size = 8
+		case 0x0F, 0x10:
+			if len(buf) < 7 {
+				return request{}, buf, false
+			}
+			size = 9 + int(buf[6])
+		default:
+			buf = buf[1:]
+			continue
+		}
+		if len(buf) < size {
+			return request{}, buf, false
+		}
+		frame := buf[:size]
+		if binary.LittleEndian.Uint16(frame[size-2:]) != crc16(frame[:size-2]) {
+			buf = buf[1:]
+			continue
+		}
+		return request{unitID: frame[0], pdu: append([]byte(nil), frame[1:size-2]...)}, buf[size:], true
+	}
+	return request{}, buf, false
+}
+
+// nextTCPRequest 从缓冲区中取出下一个Modbus TCP请求，数据不完整时返回ok=false
+func nextTCPRequest(buf []byte) (req request, rest []byte, ok bool) {
+	if len(buf) < 7 {
+		return request{}, buf, false
+	}
+	length := int(binary.BigEndian.Uint16(buf[4:6]))
+	if length < 2 || length > 254 {
+		// 报文头无效，丢弃全部数据
+		return request{}, nil, false
+	}
+	size := 6 + length
+	if len(buf) < size {
+		return request{}, buf, false
+	}
+	return request{
+		transactionID: binary.BigEndian.Uint16(buf[0:2]),
+		unitID:        buf[6],
+		pdu:           append([]byte(nil), buf[7:size]...),
+	}, buf[size:], true
+}
+
+// encodeRTU 组装RTU响应帧
+func encodeRTU(unitID uint8, pdu []byte) []byte {
+	frame := append([]byte{unitID}, pdu...)
+	crc := crc16(frame)
+	return append(frame, byte(crc), byte(crc>>8))
+}
+
+// encodeTCP 组装Modbus TCP响应帧
+func encodeTCP(transactionID uint16, unitID uint8, pdu []byte) []byte {
+	frame := make([]byte, 7+len(pdu))
+	binary.BigEndian.PutUint16(frame[0:2], transactionID)
+	binary.BigEndian.PutUint16(frame[4:6], uint16(1+len(pdu)))
+	frame[6] = unitID
+	copy(frame[7:], pdu)
+	return frame
+}
@@ -0,0 +1,272 @@
+package simulator
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// Modbus异常码
+const (
+	ExceptionIllegalFunction = 0x01
+	ExceptionIllegalAddress  = 0x02
+	ExceptionIllegalValue    = 0x03
+	ExceptionDeviceFailure   = 0x04
+)
+
+// RegisterBlock 从Start开始的连续寄存器
+type RegisterBlock struct {
+	Start  uint16   `mapstructure:"start"`
+	Values []uint16 `mapstructure:"values"`
+}
+
+// BitBlock 从Start开始的连续线圈或离散输入
+type BitBlock struct {
+	Start  uint16 `mapstructure:"start"`
+	Values []bool `mapstructure:"values"`
+}
+
+// SlaveConfig 从站配置，未配置的地址返回非法地址异常（0x02）
+type SlaveConfig struct {
+	ID               uint8           `mapstructure:"id"`
+	Coils            []BitBlock      `mapstructure:"coils"`
+	DiscreteInputs   []BitBlock      `mapstructure:"discrete_inputs"`
+	HoldingRegisters []RegisterBlock `mapstructure:"holding_registers"`
+	InputRegisters   []RegisterBlock `mapstructure:"input_registers"`
+}
+
+// Slave 模拟的Modbus从站，寄存器可以在运行中修改
+type Slave struct {
+	ID uint8
+
+	mutex            sync.Mutex
+	coils            map[uint16]bool
+	discreteInputs   map[uint16]bool
+	holdingRegisters map[uint16]uint16
+	inputRegisters   map[uint16]uint16
+}
+
+// NewSlave 按配置创建从站
+func NewSlave(config SlaveConfig) *Slave {
+	s := &Slave{
+		ID:               config.ID,
+		coils:            bitMap(config.Coils),
+		discreteInputs:   bitMap(config.DiscreteInputs),
+		holdingRegisters: registerMap(config.HoldingRegisters),
+		inputRegisters:   registerMap(config.InputRegisters),
+	}
+	return s
+}
+
+func bitMap(blocks []BitBlock) map[uint16]bool {
+	m := make(map[uint16]bool)
+	for _, block := range blocks {
+		for i, v := range block.Values {
+			m[block.Start+uint16(i)] = v
+		}
+	}
+	return m
+}
+
+func registerMap(blocks []RegisterBlock) map[uint16]uint16 {
+	m := make(map[uint16]uint16)
+	for _, block := range blocks {
+		for i, v := range block.Values {
+			m[block.Start+uint16(i)] = v
+		}
+	}
+	return m
+}
+
+// SetHoldingRegister 设置保持寄存器的值
+func (s *Slave) SetHoldingRegister(addr uint16, value uint16) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.holdingRegisters[addr] = value
+}
+
+// HoldingRegister 读取保持寄存器的值
+func (s *Slave) HoldingRegister(addr uint16) (uint16, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	v, ok := s.holdingRegisters[addr]
+	return v, ok
+}
+
+// SetInputRegister 设置输入寄存器的值
+func (s *Slave) SetInputRegister(addr uint16, value uint16) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.inputRegisters[addr] = value
+}
+
+// SetCoil 设置线圈状态
+func (s *Slave) SetCoil(addr uint16, value bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.coils[addr] = value
+}
+
+// Coil 读取线圈状态
+func (s *Slave) Coil(addr uint16) (bool, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	v, ok := s.coils[addr]
+	return v, ok
+}
+
+// SetDiscreteInput 设置离散输入状态
+func (s *Slave) SetDiscreteInput(addr uint16, value bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.discreteInputs[addr] = value
+}
+
+// exception 异常响应PDU
+func exception(functionCode byte, code byte) []byte {
+	return []byte{functionCode | 0x80, code}
+}
+
+// Handle 处理请求PDU（功能码+数据），返回响应PDU
+func (s *Slave) Handle(pdu []byte) []byte {
+	if len(pdu) == 0 {
+		return nil
+	}
+	functionCode := pdu[0]
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch functionCode {
+	case 0x01, 0x02:
+		if len(pdu) != 5 {
+			return exception(functionCode, ExceptionIllegalValue)
+		}
+		table := s.coils
+		if functionCode == 0x02 {
+			table = s.discreteInputs
+		}
+		return readBits(functionCode, table, pdu)
+	case 0x03, 0x04:
+		if len(pdu) != 5 {
+			return exception(functionCode, ExceptionIllegalValue)
+		}
+		table := s.holdingRegisters
+		if functionCode == 0x04 {
+			table = s.inputRegisters
+		}
+		return readRegisters(functionCode, table, pdu)
+	case 0x05:
+		if len(pdu) != 5 {
+			return exception(functionCode, ExceptionIllegalValue)
+		}
+		addr := binary.BigEndian.Uint16(pdu[1:3])
+		value := binary.BigEndian.Uint16(pdu[3:5])
+		if value != 0xFF00 && value != 0x0000 {
+			return exception(functionCode, ExceptionIllegalValue)
+		}
+		if _, ok := s.coils[addr]; !ok {
+			return exception(functionCode, ExceptionIllegalAddress)
+		}
+		s.coils[addr] = value == 0xFF00
+		return append([]byte(nil), pdu...)
+	case 0x06:
+		if len(pdu) != 5 {
+			return exception(functionCode, ExceptionIllegalValue)
+		}
+		addr := binary.BigEndian.Uint16(pdu[1:3])
+		if _, ok := s.holdingRegisters[addr]; !ok {
+			return exception(functionCode, ExceptionIllegalAddress)
+		}
+		s.holdingRegisters[addr] = binary.BigEndian.Uint16(pdu[3:5])
+		return append([]byte(nil), pdu...)
+	case 0x0F:
+		return writeBits(s.coils, pdu)
+	case 0x10:
+		return writeRegisters(s.holdingRegisters, pdu)
+	}
+	return exception(functionCode, ExceptionIllegalFunction)
+}
+
+func readBits(functionCode byte, table map[uint16]bool, pdu []byte) []byte {
+	start := binary.BigEndian.Uint16(pdu[1:3])
+	quantity := binary.BigEndian.Uint16(pdu[3:5])
+	if quantity == 0 || quantity > 2000 {
+		return exception(functionCode, ExceptionIllegalValue)
+	}
+	byteCount := (int(quantity) + 7) / 8
+	resp := make([]byte, 2+byteCount)
+	resp[0] = functionCode
+	resp[1] = byte(byteCount)
+	for i := 0; i < int(quantity); i++ {
+		v, ok := table[start+uint16(i)]
+		if !ok {
+			return exception(functionCode, ExceptionIllegalAddress)
+		}
+		if v {
+			resp[2+i/8] |= 1 << (i % 8)
+		}
+	}
+	return resp
+}
+
+func readRegisters(functionCode byte, table map[uint16]uint16, pdu []byte) []byte {
+	start := binary.BigEndian.Uint16(pdu[1:3])
+	quantity := binary.BigEndian.Uint16(pdu[3:5])
+	if quantity == 0 || quantity > 125 {
+		return exception(functionCode, ExceptionIllegalValue)
+	}
+	resp := make([]byte, 2+2*int(quantity))
+	resp[0] = functionCode
+	resp[1] = byte(2 * quantity)
+	for i := 0; i < int(quantity); i++ {
+		v, ok := table[start+uint16(i)]
+		if !ok {
+			return exception(functionCode, ExceptionIllegalAddress)
+		}
+		binary.BigEndian.PutUint16(resp[2+2*i:], v)
+	}
+	return resp
+}
+
+func writeBits(table map[uint16]bool, pdu []byte) []byte {
+	const functionCode = 0x0F
+	if len(pdu) < 6 {
+		return exception(functionCode, ExceptionIllegalValue)
+	}
+	start := binary.BigEndian.Uint16(pdu[1:3])
+	quantity := binary.BigEndian.Uint16(pdu[3:5])
+	byteCount := int(pdu[5])
+	if quantity == 0 || byteCount != (int(quantity)+7)/8 || len(pdu) != 6+byteCount {
+		return exception(functionCode, ExceptionIllegalValue)
+	}
+	for i := 0; i < int(quantity); i++ {
+		if _, ok := table[start+uint16(i)]; !ok {
+			return exception(functionCode, ExceptionIllegalAddress)
+		}
+	}
+	for i := 0; i < int(quantity); i++ {
+		table[start+uint16(i)] = pdu[6+i/8]&(1<<(i%8)) != 0
+	}
+	return append([]byte(nil), pdu[:5]...)
+}
+
+func writeRegisters(table map[uint16]uint16, pdu []byte) []byte {
+	const functionCode = 0x10
+	if len(pdu) < 6 {
+		return exception(functionCode, ExceptionIllegalValue)
+	}
+	start := binary.BigEndian.Uint16(pdu[1:3])
+	quantity := binary.BigEndian.Uint16(pdu[3:5])
+	byteCount := int(pdu[5])
+	if quantity == 0 || byteCount != 2*int(quantity) || len(pdu) != 6+byteCount {
+		return exception(functionCode, ExceptionIllegalValue)
+	}
+	for i := 0; i < int(quantity); i++ {
+		if _, ok := table[start+uint16(i)]; !ok {
+			return exception(functionCode, ExceptionIllegalAddress)
+		}
+	}
+	for i := 0; i < int(quantity); i++ {
+		table[start+uint16(i)] = binary.BigEndian.Uint16(pdu[6+2*i:])
+	}
+	return append([]byte(nil), pdu[:5]...)
+}
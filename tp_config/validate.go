@@ -0,0 +1,162 @@
+package tpconfig
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Knetic/govaluate"
+)
+
+// Endianesses 支持的字节序
+var Endianesses = []string{"BIG", "LITTLE", "BADC", "CDAB"}
+
+// registersPerPoint 每种数据类型占用的寄存器数量，线圈按位计
+var registersPerPoint = map[string]int{
+	"coil":    1,
+	"int16":   1,
+	"uint16":  1,
+	"int32":   2,
+	"uint32":  2,
+	"int64":   4,
+	"float32": 2,
+	"float64": 4,
+}
+
+// Identifiers 数据标识符列表
+func (c *CommandRaw) Identifiers() []string {
+	ids := strings.Split(c.DataIdetifierListStr, ",")
+	for i := range ids {
+		ids[i] = strings.TrimSpace(ids[i])
+	}
+	return ids
+}
+
+// DataLength 响应中数据部分（字节计数之后）应有的字节数
+func (c *CommandRaw) DataLength() int {
+	if c.DataType == "coil" {
+		return (int(c.Quantity) + 7) / 8
+	}
+	return int(c.Quantity) * 2
+}
+
+// Validate 检查命令配置，返回发现的所有问题，配置正确时返回nil
+func (c *CommandRaw) Validate() []error {
+	var errs []error
+	addErr := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.FunctionCode < 0x01 || c.FunctionCode > 0x04 {
+		addErr("功能码0x%02X不支持，只能是01/02/03/04", c.FunctionCode)
+	}
+	regs, ok := registersPerPoint[c.DataType]
+	if !ok {
+		addErr("数据类型%q不支持", c.DataType)
+	}
+	if c.DataType == "int64" {
+		addErr("数据类型int64暂不支持解析，采集值恒为0")
+	}
+	isBit := c.FunctionCode == 0x01 || c.FunctionCode == 0x02
+	if ok && isBit != (c.DataType == "coil") {
+		if isBit {
+			addErr("功能码0x%02X读取的是位数据，数据类型应为coil", c.FunctionCode)
+		} else {
+			addErr("数据类型coil只能用于功能码01/02")
+		}
+	}
+	if !isValidEndianess(c.Endianess) {
+		addErr("字节序%q不支持，只能是%s", c.Endianess, strings.Join(Endianesses, "/"))
+	}
+	if c.Interval <= 0 {
+		addErr("采集周期必须大于0")
+	}
+
+	// 标识符
+	ids := c.Identifiers()
+	seen := make(map[string]bool)
+	for i, id := range ids {
+		if id == "" {
+			addErr("第%d个数据标识符为空", i+1)
+			continue
+		}
+		if seen[id] {
+			addErr("数据标识符%s重复", id)
+		}
+		seen[id] = true
+	}
+
+	// 数量与标识符个数
+	if c.Quantity == 0 {
+		addErr("数量不能为0")
+	} else if ok {
+		if c.DataType == "coil" && c.Quantity > 2000 {
+			addErr("线圈数量%d超过单次读取上限2000", c.Quantity)
+		} else if c.DataType != "coil" && c.Quantity > 125 {
+			addErr("寄存器数量%d超过单次读取上限125", c.Quantity)
+		}
+		if want := len(ids) * regs; int(c.Quantity) != want {
+			addErr("数量为%d，但%d个%s类型的标识符需要%d（每个占%d）", c.Quantity, len(ids), c.DataType, want, regs)
+		}
+	}
+
+	// 公式
+	if c.EquationListStr != "" {
+		equations := strings.Split(c.EquationListStr, ",")
+		if len(equations) != 1 && len(equations) != len(ids) {
+			addErr("公式有%d个，应为1个（应用于全部标识符）或与标识符个数（%d）相同", len(equations), len(ids))
+		}
+		parameters := make(map[string]interface{}, len(ids))
+		for _, id := range ids {
+			parameters[id] = 1.0
+		}
+		for i, equation := range equations {
+			expression, err := govaluate.NewEvaluableExpression(equation)
+			if err != nil {
+				addErr("第%d个公式%q无效: %v", i+1, equation, err)
+				continue
+			}
+			undefined := false
+			for _, v := range expression.Vars() {
+				if _, found := parameters[v]; !found {
+					addErr("第%d个公式%q引用了未定义的标识符%s", i+1, equation, v)
+					undefined = true
+				}
+			}
+			if undefined {
+				continue
+			}
+			result, err := expression.Evaluate(parameters)
+			if err != nil {
+				addErr("第%d个公式%q计算失败: %v", i+1, equation, err)
+			} else if _, isFloat := result.(float64); !isFloat {
+				addErr("第%d个公式%q的结果不是数值", i+1, equation)
+			}
+		}
+	}
+
+	// 小数位数
+	if c.DecimalPlacesListStr != "" {
+		places := strings.Split(c.DecimalPlacesListStr, ",")
+		if len(places) != 1 && len(places) != len(ids) {
+			addErr("小数位数有%d个，应为1个（应用于全部标识符）或与标识符个数（%d）相同", len(places), len(ids))
+		}
+		for i, place := range places {
+			n, err := strconv.Atoi(strings.TrimSpace(place))
+			if err != nil || n < 0 {
+				addErr("第%d个小数位数%q无效，应为非负整数", i+1, place)
+			}
+		}
+	}
+
+	return errs
+}
+
+func isValidEndianess(endianess string) bool {
+	for _, e := range Endianesses {
+		if e == endianess {
+			return true
+		}
+	}
+	return false
+}